@@ -0,0 +1,136 @@
+/*
+Package cache provides a (per-task) cache of hub
+resources shared by the addon packages.
+*/
+package cache
+
+import (
+	hub "github.com/konveyor/tackle2-hub/addon"
+	"github.com/konveyor/tackle2-hub/api"
+	"sync"
+)
+
+var (
+	addon = hub.Addon
+)
+
+var (
+	// Identity cache.
+	Identity = &IdentityCache{}
+	// Proxy cache.
+	Proxy = &ProxyCache{}
+)
+
+//
+// Invalidate all cached resources.
+func Invalidate() {
+	Identity.Invalidate()
+	Proxy.Invalidate()
+}
+
+//
+// IdentityCache identity cache.
+type IdentityCache struct {
+	mutex   sync.RWMutex
+	content map[uint]api.Identity
+}
+
+//
+// Get an identity by ID.
+// Fetched from the hub when not cached.
+func (r *IdentityCache) Get(id uint) (identity *api.Identity, err error) {
+	r.mutex.RLock()
+	cached, found := r.content[id]
+	r.mutex.RUnlock()
+	if found {
+		identity = &cached
+		return
+	}
+	identity, err = addon.Identity.Get(id)
+	if err != nil {
+		return
+	}
+	r.mutex.Lock()
+	if r.content == nil {
+		r.content = make(map[uint]api.Identity)
+	}
+	r.content[id] = *identity
+	r.mutex.Unlock()
+	return
+}
+
+//
+// Prefetch the referenced identities (in parallel).
+func (r *IdentityCache) Prefetch(refs []api.Ref) (err error) {
+	var wg sync.WaitGroup
+	errs := make([]error, len(refs))
+	for i := range refs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = r.Get(refs[i].ID)
+		}(i)
+	}
+	wg.Wait()
+	for _, err = range errs {
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+//
+// Invalidate the cached identities.
+// All are invalidated when no IDs are specified.
+func (r *IdentityCache) Invalidate(ids ...uint) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(ids) == 0 {
+		r.content = nil
+		return
+	}
+	for _, id := range ids {
+		delete(r.content, id)
+	}
+}
+
+//
+// ProxyCache proxy cache.
+type ProxyCache struct {
+	mutex   sync.RWMutex
+	content []api.Proxy
+	loaded  bool
+}
+
+//
+// List the proxies.
+// Fetched from the hub when not cached.
+func (r *ProxyCache) List() (list []api.Proxy, err error) {
+	r.mutex.RLock()
+	if r.loaded {
+		list = append(list, r.content...)
+		r.mutex.RUnlock()
+		return
+	}
+	r.mutex.RUnlock()
+	fetched, err := addon.Proxy.List()
+	if err != nil {
+		return
+	}
+	r.mutex.Lock()
+	r.content = fetched
+	r.loaded = true
+	r.mutex.Unlock()
+	list = append(list, fetched...)
+	return
+}
+
+//
+// Invalidate the cached proxies.
+func (r *ProxyCache) Invalidate() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.content = nil
+	r.loaded = false
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/konveyor/tackle2-addon/teardown"
 	hub "github.com/konveyor/tackle2-hub/addon"
 )
 
@@ -10,6 +11,7 @@ var (
 
 func main() {
 	addon.Run(func() (err error) {
+		defer teardown.Run()
 		return
 	})
 }
@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	hub "github.com/konveyor/tackle2-hub/addon"
+	"os"
 	"os/exec"
 	"strings"
 )
@@ -22,7 +23,10 @@ type Command struct {
 	Options Options
 	Path    string
 	Dir     string
-	Output  []byte
+	// Env environment variables (name=value)
+	// added to the inherited environment.
+	Env    []string
+	Output []byte
 }
 
 //
@@ -37,21 +41,22 @@ func (r *Command) Run() (err error) {
 //
 // RunWith executes the command with context.
 // The command and output are both reported in
-// task Report.Activity.
+// task Report.Activity. Secrets are redacted.
 func (r *Command) RunWith(ctx context.Context) (err error) {
 	addon.Activity(
 		"[CMD] Running: %s %s",
 		r.Path,
-		strings.Join(r.Options, " "))
+		Redact(strings.Join(r.Options, " ")))
 	cmd := exec.CommandContext(ctx, r.Path, r.Options...)
 	cmd.Dir = r.Dir
+	cmd.Env = r.env()
 	r.Output, err = cmd.CombinedOutput()
 	if err != nil {
 		addon.Activity(
 			"[CMD] %s failed: %s.\n%s",
 			r.Path,
-			err.Error(),
-			string(r.Output))
+			Redact(err.Error()),
+			Redact(string(r.Output)))
 	} else {
 		addon.Activity("[CMD] succeeded.")
 	}
@@ -72,10 +77,21 @@ func (r *Command) RunSilent() (err error) {
 func (r *Command) RunSilentWith(ctx context.Context) (err error) {
 	cmd := exec.CommandContext(ctx, r.Path, r.Options...)
 	cmd.Dir = r.Dir
+	cmd.Env = r.env()
 	err = cmd.Run()
 	return
 }
 
+//
+// env returns the command environment.
+// Nil (inherited) when no variables are added.
+func (r *Command) env() (env []string) {
+	if len(r.Env) > 0 {
+		env = append(os.Environ(), r.Env...)
+	}
+	return
+}
+
 //
 // Options are CLI options.
 type Options []string
@@ -87,6 +103,14 @@ func (a *Options) Add(option string, s ...string) {
 	*a = append(*a, s...)
 }
 
+//
+// AddSecret adds an option with a secret value.
+// The value is redacted in reported activity.
+func (a *Options) AddSecret(option string, secret string) {
+	Secret(secret)
+	a.Add(option, secret)
+}
+
 //
 // add
 func (a *Options) Addf(option string, x ...interface{}) {
@@ -0,0 +1,94 @@
+package command
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	Masked = "****"
+	// MinSecret the minimum length of a registered secret.
+	// Shorter secrets would mask common text.
+	MinSecret = 4
+)
+
+var (
+	secrets = Secrets{}
+)
+
+//
+// Known credential patterns.
+// The 1st and 2nd sub-matches are preserved.
+var patterns = []*regexp.Regexp{
+	// scheme://user:password@
+	regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/@\s:]*:)[^/@\s]+(@)`),
+	// Authorization: <type> <credentials>
+	regexp.MustCompile(`(?i)(authorization:\s*(?:basic|bearer|token)\s+)\S+`),
+	// password=<value> and the like.
+	regexp.MustCompile(`(?i)((?:password|passwd|passphrase|token|secret)\s*[=:]\s*)[^\s&;,]+`),
+	// --password <value> and the like.
+	regexp.MustCompile(`(?i)(--(?:password|passphrase|token|secret)[ =])\S+`),
+}
+
+//
+// Secret registers secrets to be masked in reported activity.
+func Secret(secret ...string) {
+	secrets.Add(secret...)
+}
+
+//
+// Redact returns the string with registered secrets
+// and known credential patterns masked.
+func Redact(in string) (out string) {
+	out = secrets.Redact(in)
+	for _, p := range patterns {
+		out = p.ReplaceAllString(out, "${1}"+Masked+"${2}")
+	}
+	return
+}
+
+//
+// Secrets registry.
+type Secrets struct {
+	mutex   sync.RWMutex
+	secrets []string
+	added   map[string]bool
+}
+
+//
+// Add secrets.
+// Secrets already added or shorter than MinSecret are ignored.
+// The longest secrets are masked first.
+func (r *Secrets) Add(secret ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.added == nil {
+		r.added = make(map[string]bool)
+	}
+	for _, s := range secret {
+		if len(s) < MinSecret || r.added[s] {
+			continue
+		}
+		r.added[s] = true
+		r.secrets = append(r.secrets, s)
+	}
+	sort.Slice(
+		r.secrets,
+		func(i, j int) bool {
+			return len(r.secrets[i]) > len(r.secrets[j])
+		})
+}
+
+//
+// Redact returns the string with secrets masked.
+func (r *Secrets) Redact(in string) (out string) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	out = in
+	for _, s := range r.secrets {
+		out = strings.ReplaceAll(out, s, Masked)
+	}
+	return
+}
@@ -0,0 +1,343 @@
+/*
+Package proxy resolves the (hub) proxy used to access a URL.
+The proxy is selected by the URL scheme (http|https) and is not
+used when the URL host is excluded. SOCKS proxies are declared
+using the host: socks5://host or socks5h://host and may also be
+used for ssh. Excluded hosts may be:
+  - (*) all hosts.
+  - an exact host or IP address. Example: git.example.com.
+  - a domain which matches the domain and subdomains.
+    Example: example.com or .example.com.
+  - a wildcard pattern. Example: *.example.com.
+  - a CIDR. Example: 10.0.0.0/8.
+  - any of the above with a port. Example: git.example.com:8443.
+*/
+package proxy
+
+import (
+	"fmt"
+	"github.com/konveyor/tackle2-addon/cache"
+	"github.com/konveyor/tackle2-addon/command"
+	"github.com/konveyor/tackle2-hub/api"
+	"net"
+	urllib "net/url"
+	pathlib "path"
+	"strconv"
+	"strings"
+)
+
+//
+// Proxy a resolved proxy.
+type Proxy struct {
+	// ID the hub proxy ID.
+	ID uint
+	// Kind the proxied (URL) scheme: http|https.
+	Kind string
+	// Scheme the proxy (URL) scheme. Default: http.
+	Scheme string
+	// Host the proxy host.
+	Host string
+	// Port the proxy port.
+	Port int
+	// User the (optional) proxy user.
+	User string
+	// Password the (optional) proxy password.
+	Password string
+	// Excluded hosts.
+	Excluded []string
+}
+
+//
+// With populates the proxy using the hub proxy.
+// The host may be specified as a URL.
+func (r *Proxy) With(p *api.Proxy) {
+	r.ID = p.ID
+	r.Kind = p.Kind
+	r.Scheme = "http"
+	r.Host = p.Host
+	r.Port = p.Port
+	r.Excluded = p.Excluded
+	part := strings.SplitN(r.Host, "://", 2)
+	if len(part) == 2 {
+		r.Scheme = strings.ToLower(part[0])
+		r.Host = strings.TrimSuffix(part[1], "/")
+	}
+}
+
+//
+// Address returns host[:port].
+func (r *Proxy) Address() (address string) {
+	address = r.Host
+	if r.Port > 0 {
+		address = net.JoinHostPort(
+			r.Host,
+			strconv.Itoa(r.Port))
+	}
+	return
+}
+
+//
+// URL returns the proxy URL.
+// Includes the credentials when defined.
+func (r *Proxy) URL() (u *urllib.URL) {
+	u = &urllib.URL{
+		Scheme: r.Scheme,
+		Host:   r.Address(),
+	}
+	if r.User != "" {
+		u.User = urllib.UserPassword(r.User, r.Password)
+	}
+	return
+}
+
+//
+// String returns a description (without credentials).
+func (r *Proxy) String() (s string) {
+	s = fmt.Sprintf(
+		"(%d) %s %s://%s",
+		r.ID,
+		r.Kind,
+		r.Scheme,
+		r.Address())
+	return
+}
+
+//
+// SOCKS returns true when the proxy is a SOCKS proxy.
+func (r *Proxy) SOCKS() (socks bool) {
+	socks = strings.HasPrefix(r.Scheme, "socks")
+	return
+}
+
+//
+// Excludes returns true when the URL is excluded.
+func (r *Proxy) Excludes(url *urllib.URL) (excluded bool) {
+	port := url.Port()
+	if port == "" {
+		port = DefaultPort(url.Scheme)
+	}
+	excluded = r.ExcludesHost(url.Hostname(), port)
+	return
+}
+
+//
+// ExcludesHost returns true when the host and port are excluded.
+func (r *Proxy) ExcludesHost(host, port string) (excluded bool) {
+	host = strings.ToLower(host)
+	for _, pattern := range r.Excluded {
+		if Match(pattern, host, port) {
+			excluded = true
+			break
+		}
+	}
+	return
+}
+
+//
+// Patterns returns the excluded hosts as wildcard patterns.
+// Used by tools that support only wildcard patterns.
+// Ports and CIDRs are not supported and are omitted.
+func (r *Proxy) Patterns() (patterns []string) {
+	for _, pattern := range r.Excluded {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if _, _, err := net.ParseCIDR(pattern); err == nil {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(pattern); err == nil {
+			continue
+		}
+		switch {
+		case pattern == "":
+		case pattern == "*" || strings.Contains(pattern, "*"):
+			patterns = append(patterns, pattern)
+		case net.ParseIP(pattern) != nil:
+			patterns = append(patterns, pattern)
+		default:
+			domain := strings.TrimPrefix(pattern, ".")
+			patterns = append(
+				patterns,
+				domain,
+				"*."+domain)
+		}
+	}
+	return
+}
+
+//
+// Find returns the proxy for the URL.
+// Returns nil when the URL is not proxied.
+func Find(url string) (p *Proxy, err error) {
+	u, err := urllib.Parse(url)
+	if err != nil || u.Host == "" {
+		err = nil
+		return
+	}
+	if kindOf(u) == "" {
+		return
+	}
+	list, err := List()
+	if err != nil {
+		return
+	}
+	p = find(list, u)
+	return
+}
+
+//
+// find returns the proxy in the list for the URL.
+// The proxy is selected by kind (URL scheme).
+// Returns nil when the URL is not proxied.
+func find(list []Proxy, u *urllib.URL) (p *Proxy) {
+	kind := kindOf(u)
+	if kind == "" {
+		return
+	}
+	for i := range list {
+		if list[i].Kind != kind {
+			continue
+		}
+		if !list[i].Excludes(u) {
+			p = &list[i]
+		}
+		break
+	}
+	return
+}
+
+//
+// kindOf returns the proxy kind for the URL scheme.
+// Empty when not proxied by (http|https) kind.
+func kindOf(u *urllib.URL) (kind string) {
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		kind = "http"
+	case "https":
+		kind = "https"
+	}
+	return
+}
+
+//
+// FindSOCKS returns the SOCKS proxy for the host and port.
+// Used for protocols (ssh) proxied only using SOCKS.
+// Returns nil when the host is not proxied.
+func FindSOCKS(host, port string) (p *Proxy, err error) {
+	list, err := List()
+	if err != nil {
+		return
+	}
+	for i := range list {
+		if !list[i].SOCKS() {
+			continue
+		}
+		if !list[i].ExcludesHost(host, port) {
+			p = &list[i]
+		}
+		break
+	}
+	return
+}
+
+//
+// FindSSH returns the proxy used by ssh for the host and port.
+// The SOCKS proxy is preferred. Otherwise, the (https) proxy
+// is used (HTTP CONNECT).
+// Returns nil when the host is not proxied.
+func FindSSH(host, port string) (p *Proxy, err error) {
+	p, err = FindSOCKS(host, port)
+	if err != nil || p != nil {
+		return
+	}
+	list, err := List()
+	if err != nil {
+		return
+	}
+	for i := range list {
+		if list[i].Kind != "https" || list[i].SOCKS() {
+			continue
+		}
+		if !list[i].ExcludesHost(host, port) {
+			p = &list[i]
+		}
+		break
+	}
+	return
+}
+
+//
+// List returns the enabled proxies.
+// The credentials are populated using the proxy identity.
+func List() (list []Proxy, err error) {
+	proxies, err := cache.Proxy.List()
+	if err != nil {
+		return
+	}
+	for i := range proxies {
+		p := &proxies[i]
+		if !p.Enabled {
+			continue
+		}
+		resolved := Proxy{}
+		resolved.With(p)
+		if p.Identity != nil {
+			var id *api.Identity
+			id, err = cache.Identity.Get(p.Identity.ID)
+			if err != nil {
+				return
+			}
+			command.Secret(id.Password)
+			resolved.User = id.User
+			resolved.Password = id.Password
+		}
+		list = append(list, resolved)
+	}
+	return
+}
+
+//
+// Match returns true when the excluded host pattern
+// matches the host and port. See: package doc.
+func Match(pattern, host, port string) (matched bool) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if pattern == "" {
+		return
+	}
+	if pattern == "*" {
+		matched = true
+		return
+	}
+	if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		matched = ip != nil && cidr.Contains(ip)
+		return
+	}
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		if p != port {
+			return
+		}
+		pattern = h
+	}
+	pattern = strings.Trim(pattern, "[]")
+	if strings.Contains(pattern, "*") {
+		matched, _ = pathlib.Match(pattern, host)
+		return
+	}
+	domain := strings.TrimPrefix(pattern, ".")
+	matched = host == domain || strings.HasSuffix(host, "."+domain)
+	return
+}
+
+//
+// DefaultPort returns the default port for the scheme.
+func DefaultPort(scheme string) (port string) {
+	switch strings.ToLower(scheme) {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	case "ssh":
+		port = "22"
+	}
+	return
+}
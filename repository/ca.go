@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-hub/api"
+	"os"
+	pathlib "path"
+	"strings"
+)
+
+const (
+	// CASetting the (trusted) CA certificates setting key.
+	// The value is a map of host pattern to CA certificates (PEM).
+	// The (*) pattern is the default for all hosts.
+	CASetting = "tls.ca.bundles"
+	// CAKind CA certificate identity kind.
+	CAKind = "ca"
+)
+
+// SystemCAFiles the well-known system CA bundles.
+var SystemCAFiles = []string{
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/ssl/cert.pem",
+}
+
+// CABundle trusted CA certificates.
+// Provided by an identity with kind=ca and the certificates (PEM)
+// in the settings or by the CASetting. The identity (matched by the
+// patterns declared in the identity settings) overrides the setting.
+//
+// Example identity settings:
+//
+//	hosts:
+//	  - git.example.com
+//	certificate: |
+//	  -----BEGIN CERTIFICATE-----
+//	  ...
+type CABundle struct {
+	// Source describes where the bundle is defined.
+	Source string
+	// Certificates (PEM).
+	Certificates string
+}
+
+// With populates the bundle using the identity.
+func (r *CABundle) With(id *api.Identity) {
+	settings := IdentitySettings{}
+	settings.With(id)
+	r.Source = "identity: " + id.Name
+	r.Certificates = settings.Certificate
+}
+
+// Validate the certificates.
+func (r *CABundle) Validate() (err error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(r.Certificates)) {
+		err = liberr.New(
+			"CA certificates (PEM) not valid.",
+			"source",
+			r.Source)
+	}
+	return
+}
+
+// Split returns each certificate (PEM).
+func (r *CABundle) Split() (certificates []string) {
+	rest := []byte(r.Certificates)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificates = append(
+			certificates,
+			string(pem.EncodeToMemory(block)))
+	}
+	return
+}
+
+// Write the bundle file.
+// The file contains the system CA certificates followed
+// by the bundle so that other hosts are still trusted.
+func (r *CABundle) Write(path string) (err error) {
+	content := ""
+	for _, system := range SystemCAFiles {
+		b, rErr := os.ReadFile(system)
+		if rErr == nil {
+			content = string(b)
+			break
+		}
+	}
+	content = strings.TrimSpace(content) + "\n" + r.Certificates
+	err = writeFile(path, content)
+	return
+}
+
+// CABundles returns the CA certificates defined in the settings.
+func CABundles() (bundles map[string]string, err error) {
+	bundles = make(map[string]string)
+	_, err = setting(CASetting, &bundles)
+	return
+}
+
+// findCA finds the CA certificates for the URL.
+// The identity (kind=ca) is preferred followed by the TLS policy
+// for the host. Otherwise, the best match of the host patterns in
+// the CASetting: exact, wildcard and then (*).
+func (r *Remote) findCA(url string) (bundle *CABundle, found bool, err error) {
+	id, found, err := r.findIdentityFor(CAKind, url)
+	if err != nil {
+		return
+	}
+	if found {
+		bundle = &CABundle{}
+		bundle.With(id)
+		err = bundle.Validate()
+		return
+	}
+	policies, err := Policies()
+	if err != nil {
+		return
+	}
+	var patterns []string
+	for pattern := range policies {
+		patterns = append(patterns, pattern)
+	}
+	pattern, found := bestMatch(patterns, url)
+	if found && policies[pattern].CA != "" {
+		bundle = &CABundle{
+			Source:       "policy: " + pattern,
+			Certificates: policies[pattern].CA,
+		}
+		err = bundle.Validate()
+		return
+	}
+	bundles, err := CABundles()
+	if err != nil {
+		return
+	}
+	patterns = nil
+	for pattern = range bundles {
+		patterns = append(patterns, pattern)
+	}
+	pattern, found = bestMatch(patterns, url)
+	if found {
+		bundle = &CABundle{
+			Source:       "setting: " + pattern,
+			Certificates: bundles[pattern],
+		}
+		err = bundle.Validate()
+	}
+	return
+}
+
+// writeCA writes the CA bundle file for the URL.
+// The path is empty when no CA certificates are defined.
+func (r *Remote) writeCA(url string) (path string, bundle *CABundle, err error) {
+	bundle, found, err := r.findCA(url)
+	if err != nil || !found {
+		return
+	}
+	home, err := r.home()
+	if err != nil {
+		return
+	}
+	path = pathlib.Join(home, "ca.crt")
+	err = bundle.Write(path)
+	return
+}
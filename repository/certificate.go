@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-addon/command"
+	"github.com/konveyor/tackle2-addon/ssh"
+	"github.com/konveyor/tackle2-hub/api"
+	"os"
+	pathlib "path"
+	"strings"
+)
+
+const (
+	// CertificateKind client certificate identity kind.
+	CertificateKind = "certificate"
+)
+
+// ClientCertificate (mutual TLS) client certificate.
+// Provided by an identity with kind=certificate:
+//   - Key: the private key (PEM).
+//   - Password: the (optional) private key passphrase.
+//   - Settings: the certificate (PEM) and host or URL patterns.
+//     The patterns are required. The certificate is sent only
+//     to matching hosts.
+//
+// Example settings:
+//
+//	hosts:
+//	  - git.example.com
+//	certificate: |
+//	  -----BEGIN CERTIFICATE-----
+//	  ...
+type ClientCertificate struct {
+	Identity    *api.Identity
+	Certificate string
+	Key         string
+	Passphrase  string
+}
+
+// With populates the certificate using the identity.
+func (r *ClientCertificate) With(id *api.Identity) {
+	settings := IdentitySettings{}
+	settings.With(id)
+	r.Identity = id
+	r.Certificate = settings.Certificate
+	r.Key = id.Key
+	r.Passphrase = id.Password
+}
+
+// WritePEM writes the certificate and key (PEM) files.
+func (r *ClientCertificate) WritePEM(dir string) (certPath, keyPath string, err error) {
+	certPath = pathlib.Join(dir, "client.crt")
+	err = writeFile(certPath, r.Certificate)
+	if err != nil {
+		return
+	}
+	keyPath = pathlib.Join(dir, "client.key")
+	err = writeFile(keyPath, r.Key)
+	return
+}
+
+// WritePKCS12 writes the certificate and key PKCS#12 file.
+// The file is protected by a generated password.
+func (r *ClientCertificate) WritePKCS12(dir string) (path, password string, err error) {
+	certPath, keyPath, err := r.WritePEM(dir)
+	if err != nil {
+		return
+	}
+	password, err = newPassword()
+	if err != nil {
+		return
+	}
+	path = pathlib.Join(dir, "client.p12")
+	cmd := command.Command{Path: "/usr/bin/openssl"}
+	cmd.Options.Add("pkcs12", "-export")
+	cmd.Options.Add("-in", certPath)
+	cmd.Options.Add("-inkey", keyPath)
+	cmd.Options.Add("-out", path)
+	cmd.Options.Add("-passin", "env:KEY_PASSWORD")
+	cmd.Options.Add("-passout", "env:P12_PASSWORD")
+	cmd.Env = append(
+		cmd.Env,
+		"KEY_PASSWORD="+r.Passphrase,
+		"P12_PASSWORD="+password)
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			path)
+	}
+	return
+}
+
+// TLSCertificate returns the certificate and (decrypted) key.
+// Encrypted (PKCS#8 or legacy PEM) keys are decrypted using the passphrase.
+func (r *ClientCertificate) TLSCertificate() (cert tls.Certificate, err error) {
+	key := []byte(r.Key)
+	block, _ := pem.Decode(key)
+	if block == nil {
+		err = liberr.New(
+			"Client certificate key (PEM) not valid.",
+			"identity",
+			r.Identity.Name)
+		return
+	}
+	var der []byte
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		encrypted := ssh.EncryptedPKCS8{}
+		_, err = asn1.Unmarshal(block.Bytes, &encrypted)
+		if err == nil {
+			der, err = encrypted.Decrypt(r.Passphrase)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY"}
+	case x509.IsEncryptedPEMBlock(block):
+		der, err = x509.DecryptPEMBlock(block, []byte(r.Passphrase))
+		block = &pem.Block{Type: block.Type}
+	}
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"identity",
+			r.Identity.Name)
+		return
+	}
+	if der != nil {
+		block.Bytes = der
+		key = pem.EncodeToMemory(block)
+	}
+	cert, err = tls.X509KeyPair([]byte(r.Certificate), key)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"identity",
+			r.Identity.Name)
+	}
+	return
+}
+
+// findCertificate finds the client certificate for the URL.
+func (r *Remote) findCertificate(url string) (cert *ClientCertificate, found bool, err error) {
+	id, found, err := r.findIdentityFor(CertificateKind, url)
+	if err != nil || !found {
+		return
+	}
+	cert = &ClientCertificate{}
+	cert.With(id)
+	if cert.Certificate == "" || cert.Key == "" {
+		err = liberr.New(
+			"Client certificate and key required.",
+			"identity",
+			id.Name)
+	}
+	return
+}
+
+// newPassword returns a generated (random) password.
+func newPassword() (password string, err error) {
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	password = hex.EncodeToString(b)
+	command.Secret(password)
+	return
+}
+
+// writeFile writes a (private) file.
+func writeFile(path, content string) (err error) {
+	f, err := os.OpenFile(
+		path,
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC,
+		0600)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			path)
+		return
+	}
+	_, err = f.Write([]byte(strings.TrimSpace(content) + "\n"))
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			path)
+	}
+	_ = f.Close()
+	addon.Activity("[FILE] Created %s.", path)
+	return
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/konveyor/tackle2-hub/api"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestClientCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	der, err = x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	block, err := x509.EncryptPEMBlock(
+		rand.Reader,
+		"EC PRIVATE KEY",
+		der,
+		[]byte("secret"),
+		x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := string(pem.EncodeToMemory(block))
+	cases := []struct {
+		name       string
+		key        string
+		passphrase string
+		wantErr    bool
+	}{
+		{name: "plain", key: plain},
+		{name: "encrypted", key: encrypted, passphrase: "secret"},
+		{name: "wrong passphrase", key: encrypted, passphrase: "wrong", wantErr: true},
+		{name: "not pem", key: "not a key", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cert := ClientCertificate{
+				Identity:    &api.Identity{Name: c.name},
+				Certificate: certificate,
+				Key:         c.key,
+				Passphrase:  c.passphrase,
+			}
+			tlsCert, err := cert.TLSCertificate()
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected error.")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tlsCert.Certificate) != 1 || tlsCert.PrivateKey == nil {
+				t.Fatalf("certificate not complete.")
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"bufio"
+	"errors"
+	liberr "github.com/jortel/go-utils/error"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Credential served to git.
+type Credential struct {
+	// Protocol (scheme). Empty matches any.
+	Protocol string
+	// Host (host[:port]).
+	Host string
+	// Path prefix. Empty matches any.
+	Path string
+	// User name.
+	User string
+	// Password (or token).
+	Password string
+}
+
+// Match returns true when the credential matches the request.
+func (r *Credential) Match(protocol, host, path string) (matched bool) {
+	if r.Protocol != "" && r.Protocol != protocol {
+		return
+	}
+	if !strings.EqualFold(r.Host, host) {
+		return
+	}
+	if r.Path != "" {
+		path = strings.TrimSuffix(path, ".git")
+		if path != r.Path && !strings.HasPrefix(path, r.Path+"/") {
+			return
+		}
+	}
+	matched = true
+	return
+}
+
+// CredentialServer serves git credentials held in memory
+// using the git credential-cache daemon protocol. Git is
+// configured with: helper = cache --socket=<path>.
+// The credentials are never written to disk.
+type CredentialServer struct {
+	// Path of the (unix) socket.
+	Path        string
+	credentials []Credential
+	listener    net.Listener
+	mutex       sync.RWMutex
+}
+
+// Start listening on the socket.
+func (r *CredentialServer) Start() (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.listener != nil {
+		return
+	}
+	_ = os.Remove(r.Path)
+	r.listener, err = net.Listen("unix", r.Path)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			r.Path)
+		return
+	}
+	err = os.Chmod(r.Path, 0600)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			r.Path)
+		return
+	}
+	go r.serve(r.listener)
+	return
+}
+
+// Stop listening and forget the credentials.
+func (r *CredentialServer) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.credentials = nil
+	if r.listener != nil {
+		_ = r.listener.Close()
+		r.listener = nil
+	}
+}
+
+// Set the credentials served.
+func (r *CredentialServer) Set(credentials ...Credential) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.credentials = credentials
+}
+
+// Add credentials served.
+func (r *CredentialServer) Add(credentials ...Credential) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.credentials = append(r.credentials, credentials...)
+}
+
+// serve accepts connections.
+func (r *CredentialServer) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go r.handle(conn)
+	}
+}
+
+// handle a request.
+// The request contains the action followed by the credential
+// attributes. The matched credential is returned only for
+// the (get) action. Other actions (store, erase, exit) are ignored.
+func (r *CredentialServer) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	request := make(map[string]string)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		part := strings.SplitN(line, "=", 2)
+		if len(part) == 2 {
+			request[part[0]] = part[1]
+		}
+	}
+	if request["action"] != "get" {
+		return
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for i := range r.credentials {
+		c := &r.credentials[i]
+		if !c.Match(request["protocol"], request["host"], request["path"]) {
+			continue
+		}
+		reply := ""
+		if c.User != "" {
+			reply += "username=" + c.User + "\n"
+		}
+		reply += "password=" + c.Password + "\n"
+		_, _ = conn.Write([]byte(reply))
+		break
+	}
+}
@@ -1,9 +1,18 @@
 package repository
 
 import (
+	"errors"
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-addon/command"
+	"github.com/konveyor/tackle2-addon/teardown"
 	hub "github.com/konveyor/tackle2-hub/addon"
 	"github.com/konveyor/tackle2-hub/api"
+	"github.com/konveyor/tackle2-hub/nas"
 	"os"
+	pathlib "path"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 var (
@@ -16,23 +25,31 @@ func init() {
 }
 
 // New SCM repository factory.
+// The URL rewrite rules and mirrors defined in the settings are applied.
 func New(destDir string, remote *api.Repository, identities []api.Ref) (r SCM, err error) {
+	repository := *remote
+	rm := Remote{
+		Repository: &repository,
+		Identities: identities,
+	}
+	err = rm.rewrite()
+	if err != nil {
+		return
+	}
+	err = rm.findMirrors()
+	if err != nil {
+		return
+	}
 	switch remote.Kind {
 	case "subversion":
 		r = &Subversion{
-			Path: destDir,
-			Remote: Remote{
-				Repository: remote,
-				Identities: identities,
-			},
+			Path:   destDir,
+			Remote: rm,
 		}
 	default:
 		r = &Git{
-			Path: destDir,
-			Remote: Remote{
-				Repository: remote,
-				Identities: identities,
-			},
+			Path:   destDir,
+			Remote: rm,
 		}
 	}
 	err = r.Validate()
@@ -45,27 +62,228 @@ type SCM interface {
 	Fetch() (err error)
 	Branch(name string) (err error)
 	Commit(files []string, msg string) (err error)
+	// Apply the patch file (path). Relative to the current directory.
+	Apply(patch string) (rejected []Reject, err error)
+	Status() (status *Status, err error)
+	Diff() (diff string, err error)
+	Log(filter LogFilter) (commits []Commit, err error)
+	ListRefs() (refs *Refs, err error)
+	Verify() (result *Verification, err error)
+}
+
+// Refs remote references.
+type Refs struct {
+	Default  string
+	Branches []string
+	Tags     []string
+}
+
+// Status of the working copy.
+type Status struct {
+	Modified  []string
+	Added     []string
+	Deleted   []string
+	Untracked []string
+}
+
+// Changed returns all changed files.
+func (r *Status) Changed() (files []string) {
+	files = append(files, r.Modified...)
+	files = append(files, r.Added...)
+	files = append(files, r.Deleted...)
+	files = append(files, r.Untracked...)
+	return
+}
+
+// Reject describes a patch hunk that was not applied.
+type Reject struct {
+	// Path of the patched file.
+	Path string
+	// Hunk identifies the hunk within the file.
+	Hunk string
+	// Conflict indicates the hunk was merged with conflict markers.
+	// The file contains the conflict markers and (git) unmerged index
+	// entries. Commit() with no files stages and commits the file
+	// (with the markers) without warning. Resolve before committing.
+	Conflict bool
+}
+
+// patchPath returns the absolute path of the patch file.
+func patchPath(patch string) (path string, err error) {
+	path, err = filepath.Abs(patch)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"patch",
+			patch)
+		return
+	}
+	if st.IsDir() {
+		err = liberr.New(
+			"patch must be a file.",
+			"patch",
+			patch)
+	}
+	return
+}
+
+// removeRejects removes the reject files (path+suffix) written
+// for the rejected hunks so they are not committed.
+func removeRejects(dir string, rejected []Reject, suffix string) {
+	for _, reject := range rejected {
+		if reject.Conflict || reject.Path == "" {
+			continue
+		}
+		_ = os.Remove(pathlib.Join(dir, reject.Path+suffix))
+	}
+}
+
+// LogFilter commit history filter.
+type LogFilter struct {
+	// Path limits the history to commits touching the path.
+	Path string
+	// Since limits the history to commits on or after the date.
+	Since time.Time
+	// Until limits the history to commits on or before the date.
+	Until time.Time
+	// Max number of commits. Unlimited when zero.
+	Max int
+}
+
+// Match returns true when the date is within the filter range.
+func (r *LogFilter) Match(date time.Time) (matched bool) {
+	if !r.Since.IsZero() && date.Before(r.Since) {
+		return
+	}
+	if !r.Until.IsZero() && date.After(r.Until) {
+		return
+	}
+	matched = true
+	return
+}
+
+// Commit history entry.
+type Commit struct {
+	ID      string
+	Author  string
+	Email   string
+	Date    time.Time
+	Message string
+	Files   []string
+}
+
+// Verification result of (deep) validation against the remote.
+type Verification struct {
+	// Reachable the remote host is reachable.
+	Reachable bool `json:"reachable"`
+	// Authenticated the selected identity was accepted.
+	Authenticated bool `json:"authenticated"`
+	// RefExists the branch (or maven repository) exists.
+	RefExists bool `json:"refExists"`
+	// Error reported by the remote.
+	Error string `json:"error,omitempty"`
+}
+
+// With updates the verification based on (command) output.
+// Known connectivity and authentication failure
+// messages are matched. Other failures are considered
+// to be reported by a reachable host with valid credentials.
+func (r *Verification) With(output []byte) {
+	r.Error = command.Redact(strings.TrimSpace(string(output)))
+	s := strings.ToLower(r.Error)
+	for _, m := range []string{
+		"could not resolve",
+		"connection refused",
+		"connection timed out",
+		"operation timed out",
+		"failed to connect",
+		"no route to host",
+		"unable to connect",
+		"network is unreachable",
+		"e170013",
+		"e670002",
+		"e670008",
+	} {
+		if strings.Contains(s, m) {
+			return
+		}
+	}
+	r.Reachable = true
+	for _, m := range []string{
+		"authentication failed",
+		"authorization failed",
+		"could not read username",
+		"could not read password",
+		"permission denied",
+		"access denied",
+		"returned error: 401",
+		"returned error: 403",
+		"repository not found",
+		"e215004",
+		"e170001",
+	} {
+		if strings.Contains(s, m) {
+			return
+		}
+	}
+	r.Authenticated = true
+}
+
+// setting gets an optional setting.
+// Returns found=false when the setting is not defined.
+func setting(key string, v interface{}) (found bool, err error) {
+	err = addon.Setting.Get(key, v)
+	if err != nil {
+		if errors.Is(err, &hub.NotFound{}) {
+			err = nil
+		}
+		return
+	}
+	found = true
+	return
 }
 
 // Remote repository.
 type Remote struct {
 	*api.Repository
 	Identities []api.Ref
+	// Mirrors alternate URLs tried in order by Fetch.
+	Mirrors []string
+	// Mirror the mirror URL used by Fetch.
+	// Empty when fetched using the URL.
+	Mirror string
+	// Home the (private) directory containing the configuration
+	// and credentials scoped to the remote.
+	Home string
 }
 
-// FindIdentity by kind.
-func (r *Remote) findIdentity(kind string) (matched *api.Identity, found bool, err error) {
-	for _, ref := range r.Identities {
-		identity, nErr := addon.Identity.Get(ref.ID)
-		if nErr != nil {
-			err = nErr
-			return
-		}
-		if identity.Kind == kind {
-			found = true
-			matched = identity
-			break
-		}
+// home returns the directory containing the configuration
+// and credentials scoped to the remote. Created as needed and
+// registered to be (securely) deleted on teardown.
+func (r *Remote) home() (dir string, err error) {
+	if r.Home != "" {
+		dir = r.Home
+		return
+	}
+	root := pathlib.Join(HomeDir, ".remote")
+	err = nas.MkDir(root, 0700)
+	if err != nil {
+		return
+	}
+	dir, err = os.MkdirTemp(root, "")
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			root)
+		return
 	}
+	r.Home = dir
+	teardown.File(dir)
 	return
 }
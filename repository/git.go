@@ -1,23 +1,32 @@
 package repository
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	liberr "github.com/jortel/go-utils/error"
 	"github.com/konveyor/tackle2-addon/command"
+	pxy "github.com/konveyor/tackle2-addon/proxy"
 	"github.com/konveyor/tackle2-addon/ssh"
+	"github.com/konveyor/tackle2-addon/teardown"
 	"github.com/konveyor/tackle2-hub/api"
 	"github.com/konveyor/tackle2-hub/nas"
-	urllib "net/url"
 	"os"
 	pathlib "path"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // Git repository.
 type Git struct {
 	Remote
-	Path string
+	Path        string
+	credentials CredentialServer
+	// envConfig configuration (name=value) passed
+	// using the environment. Not written to disk.
+	envConfig []string
+	// sshCommand the (GIT_SSH_COMMAND) ssh command.
+	sshCommand string
 }
 
 // Validate settings.
@@ -27,25 +36,161 @@ func (r *Git) Validate() (err error) {
 	if err != nil {
 		return
 	}
-	insecure, err := addon.Setting.Bool("git.insecure.enabled")
+	policy, err := r.findPolicy(r.Remote.URL, "git.insecure.enabled")
 	if err != nil {
 		return
 	}
-	switch u.Scheme {
-	case "http":
-		if !insecure {
-			err = errors.New("http URL used with git.insecure.enabled = FALSE")
+	err = policy.Validate(u.Scheme, command.Redact(r.Remote.URL))
+	return
+}
+
+// Fetch clones the repository.
+// The mirrors are tried in order when the clone fails.
+// When cloned from a mirror, changes are pushed to the URL.
+func (r *Git) Fetch() (err error) {
+	primary := r.Remote.URL
+	for _, url := range r.urls() {
+		r.Remote.URL = url
+		err = r.fetch()
+		if err == nil {
+			if url != primary {
+				r.Mirror = url
+				addon.Activity("[GIT] Cloned using mirror: %s", command.Redact(url))
+			}
+			break
+		}
+	}
+	r.Remote.URL = primary
+	if err != nil {
+		return
+	}
+	if r.Mirror != "" {
+		url := r.URL()
+		cmd := r.newCommand()
+		cmd.Dir = r.Path
+		cmd.Options.Add("remote", "set-url", "--push", "origin", url.String())
+		err = cmd.Run()
+		if err != nil {
 			return
 		}
 	}
+	err = r.checkout()
 	return
 }
 
-// Fetch clones the repository.
-func (r *Git) Fetch() (err error) {
+// fetch clones the repository using the (current) URL.
+func (r *Git) fetch() (err error) {
 	url := r.URL()
-	addon.Activity("[GIT] Cloning: %s", url.String())
+	addon.Activity("[GIT] Cloning: %s", command.Redact(url.String()))
 	_ = nas.RmDir(r.Path)
+	err = r.prepare()
+	if err != nil {
+		return
+	}
+	cmd := r.newCommand()
+	cmd.Options.Add("clone", url.String(), r.Path)
+	err = cmd.Run()
+	return
+}
+
+// ListRefs lists the remote references without cloning.
+func (r *Git) ListRefs() (refs *Refs, err error) {
+	url := r.URL()
+	addon.Activity("[GIT] Listing references: %s", command.Redact(url.String()))
+	err = r.prepare()
+	if err != nil {
+		return
+	}
+	cmd := r.newCommand()
+	cmd.Options.Add("ls-remote", "--symref", url.String())
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	refs = r.refs(cmd.Output)
+	return
+}
+
+// Verify validates the URL and settings and verifies the remote
+// is reachable, the credentials are accepted and the branch exists.
+func (r *Git) Verify() (result *Verification, err error) {
+	err = r.Validate()
+	if err != nil {
+		return
+	}
+	url := r.URL()
+	addon.Activity("[GIT] Verifying: %s", command.Redact(url.String()))
+	err = r.prepare()
+	if err != nil {
+		return
+	}
+	ctx, fn := context.WithTimeout(context.TODO(), time.Minute)
+	defer fn()
+	result = &Verification{}
+	cmd := r.newCommand()
+	cmd.Options.Add("ls-remote", "--symref", url.String())
+	err = cmd.RunWith(ctx)
+	if err != nil {
+		err = nil
+		result.With(cmd.Output)
+		return
+	}
+	result.Reachable = true
+	result.Authenticated = true
+	refs := r.refs(cmd.Output)
+	branch := r.Remote.Branch
+	if branch == "" {
+		result.RefExists = refs.Default != ""
+		return
+	}
+	for _, ref := range append(refs.Branches, refs.Tags...) {
+		if ref == branch {
+			result.RefExists = true
+			break
+		}
+	}
+	return
+}
+
+// refs parses `git ls-remote --symref` output.
+func (r *Git) refs(output []byte) (refs *Refs) {
+	refs = &Refs{}
+	for _, line := range strings.Split(string(output), "\n") {
+		part := strings.Split(line, "\t")
+		if len(part) != 2 {
+			continue
+		}
+		ref := part[0]
+		if part[1] == "HEAD" {
+			if strings.HasPrefix(ref, "ref: ") {
+				refs.Default = strings.TrimPrefix(
+					strings.TrimPrefix(ref, "ref: "),
+					"refs/heads/")
+			}
+			continue
+		}
+		ref = part[1]
+		switch {
+		case strings.HasSuffix(ref, "^{}"):
+		case strings.HasPrefix(ref, "refs/heads/"):
+			refs.Branches = append(
+				refs.Branches,
+				strings.TrimPrefix(ref, "refs/heads/"))
+		case strings.HasPrefix(ref, "refs/tags/"):
+			refs.Tags = append(
+				refs.Tags,
+				strings.TrimPrefix(ref, "refs/tags/"))
+		}
+	}
+	return
+}
+
+// prepare writes the configuration and credentials
+// and adds the ssh key used to access the remote.
+// The key is added to the agent only for ssh URLs.
+func (r *Git) prepare() (err error) {
+	r.envConfig = nil
+	url := r.URL()
 	id, found, err := r.findIdentity("source")
 	if err != nil {
 		return
@@ -62,33 +207,38 @@ func (r *Git) Fetch() (err error) {
 	if err != nil {
 		return
 	}
-	err = r.writeCreds(id)
+	r.credentials.Set()
+	err = r.serveCreds(id)
 	if err != nil {
 		return
 	}
-	agent := ssh.Agent{}
-	err = agent.Add(id, url.Host)
+	err = r.useCertificate()
 	if err != nil {
 		return
 	}
-	cmd := command.Command{Path: "/usr/bin/git"}
-	cmd.Options.Add("clone", url.String(), r.Path)
-	err = cmd.Run()
+	err = r.useCA()
 	if err != nil {
 		return
 	}
-	err = r.checkout()
+	agent, err := r.useSSH(id)
+	if err != nil {
+		return
+	}
+	if url.Scheme != "ssh" {
+		return
+	}
+	err = agent.Add(id, url.Address())
 	return
 }
 
 // Branch creates a branch with the given name if not exist and switch to it.
 func (r *Git) Branch(name string) (err error) {
-	cmd := command.Command{Path: "/usr/bin/git"}
+	cmd := r.newCommand()
 	cmd.Dir = r.Path
 	cmd.Options.Add("checkout", name)
 	err = cmd.Run()
 	if err != nil {
-		cmd = command.Command{Path: "/usr/bin/git"}
+		cmd = r.newCommand()
 		cmd.Dir = r.Path
 		cmd.Options.Add("checkout", "-b", name)
 	}
@@ -98,19 +248,28 @@ func (r *Git) Branch(name string) (err error) {
 
 // addFiles adds files to staging area.
 func (r *Git) addFiles(files []string) (err error) {
-	cmd := command.Command{Path: "/usr/bin/git"}
+	cmd := r.newCommand()
 	cmd.Dir = r.Path
 	cmd.Options.Add("add", files...)
 	return cmd.Run()
 }
 
 // Commit files and push to remote.
+// When no files are specified, all changed files are committed.
 func (r *Git) Commit(files []string, msg string) (err error) {
+	if len(files) == 0 {
+		var status *Status
+		status, err = r.Status()
+		if err != nil {
+			return
+		}
+		files = status.Changed()
+	}
 	err = r.addFiles(files)
 	if err != nil {
 		return err
 	}
-	cmd := command.Command{Path: "/usr/bin/git"}
+	cmd := r.newCommand()
 	cmd.Dir = r.Path
 	cmd.Options.Add("commit")
 	cmd.Options.Add("--message", msg)
@@ -122,13 +281,218 @@ func (r *Git) Commit(files []string, msg string) (err error) {
 }
 
 // push changes to remote.
+// When cloned from a mirror, the configuration, credentials
+// and ssh key are prepared for the URL (pushed to) first.
 func (r *Git) push() (err error) {
-	cmd := command.Command{Path: "/usr/bin/git"}
+	if r.Mirror != "" {
+		err = r.prepare()
+		if err != nil {
+			return
+		}
+	}
+	cmd := r.newCommand()
 	cmd.Dir = r.Path
 	cmd.Options.Add("push", "--set-upstream", "origin", r.Remote.Branch)
 	return cmd.Run()
 }
 
+// Status returns the working tree status.
+func (r *Git) Status() (status *Status, err error) {
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("status", "--porcelain=v1", "-z", "--untracked-files=all")
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	status = &Status{}
+	entries := strings.Split(string(cmd.Output), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		x, y, path := entry[0], entry[1], entry[3:]
+		switch {
+		case x == '?':
+			status.Untracked = append(status.Untracked, path)
+		case x == 'R':
+			status.Added = append(status.Added, path)
+			i++
+			if i < len(entries) {
+				status.Deleted = append(status.Deleted, entries[i])
+			}
+		case x == 'C':
+			status.Added = append(status.Added, path)
+			i++
+		case x == 'A':
+			status.Added = append(status.Added, path)
+		case x == 'D' || y == 'D':
+			status.Deleted = append(status.Deleted, path)
+		default:
+			status.Modified = append(status.Modified, path)
+		}
+	}
+	return
+}
+
+// Diff returns the (unified) diff of tracked files
+// in the working tree and index against HEAD.
+func (r *Git) Diff() (diff string, err error) {
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("diff", "HEAD")
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	diff = string(cmd.Output)
+	return
+}
+
+// Log returns the commit history.
+func (r *Git) Log(filter LogFilter) (commits []Commit, err error) {
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("log")
+	cmd.Options.Add("--format=%x1e%H%x1f%an%x1f%ae%x1f%aI%x1f%B%x1f")
+	cmd.Options.Add("--name-only")
+	if !filter.Since.IsZero() {
+		cmd.Options.Addf("--since=%s", filter.Since.Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		cmd.Options.Addf("--until=%s", filter.Until.Format(time.RFC3339))
+	}
+	if filter.Max > 0 {
+		cmd.Options.Addf("--max-count=%d", filter.Max)
+	}
+	if filter.Path != "" {
+		cmd.Options.Add("--", filter.Path)
+	}
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	for _, record := range strings.Split(string(cmd.Output), "\x1e") {
+		fields := strings.Split(record, "\x1f")
+		if len(fields) != 6 {
+			continue
+		}
+		commit := Commit{
+			ID:      fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Message: strings.TrimSpace(fields[4]),
+		}
+		commit.Date, err = time.Parse(time.RFC3339, fields[3])
+		if err != nil {
+			err = liberr.Wrap(err)
+			return
+		}
+		for _, path := range strings.Split(fields[5], "\n") {
+			path = strings.TrimSpace(path)
+			if path != "" {
+				commit.Files = append(commit.Files, path)
+			}
+		}
+		commits = append(commits, commit)
+	}
+	return
+}
+
+// Apply applies a patch file (git or plain unified diff) to the working
+// copy. The patch is the path of the file (relative to the current
+// directory, not the working copy).
+// When the patch does not apply cleanly, a 3-way merge is attempted and
+// then the hunks that apply are applied individually.
+// Hunks that could not be applied (or merged with conflicts) are returned.
+// The (*.rej) reject files are removed. Files merged with conflicts
+// contain conflict markers (see: Reject.Conflict).
+func (r *Git) Apply(patch string) (rejected []Reject, err error) {
+	patch, err = patchPath(patch)
+	if err != nil {
+		return
+	}
+	addon.Activity("[GIT] Applying patch: %s", patch)
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("apply", "--check", patch)
+	err = cmd.RunSilent()
+	if err == nil {
+		cmd = r.newCommand()
+		cmd.Dir = r.Path
+		cmd.Options.Add("apply", patch)
+		err = cmd.Run()
+		return
+	}
+	cmd = r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("apply", "--3way", patch)
+	err = cmd.Run()
+	if err == nil {
+		return
+	}
+	rejected = r.conflicts(cmd.Output)
+	if len(rejected) > 0 {
+		err = nil
+		return
+	}
+	cmd = r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("apply", "--reject", patch)
+	err = cmd.Run()
+	if err == nil {
+		return
+	}
+	rejected = r.rejects(cmd.Output)
+	if len(rejected) > 0 {
+		removeRejects(r.Path, rejected, ".rej")
+		err = nil
+	}
+	return
+}
+
+// conflicts parses `git apply --3way` output for files merged with conflicts.
+func (r *Git) conflicts(output []byte) (rejected []Reject) {
+	pattern := regexp.MustCompile(`^Applied patch to '(.+)' with conflicts\.$`)
+	for _, line := range strings.Split(string(output), "\n") {
+		m := pattern.FindStringSubmatch(line)
+		if m != nil {
+			rejected = append(
+				rejected,
+				Reject{
+					Path:     m[1],
+					Conflict: true,
+				})
+		}
+	}
+	return
+}
+
+// rejects parses `git apply --reject` output for rejected hunks.
+func (r *Git) rejects(output []byte) (rejected []Reject) {
+	applying := regexp.MustCompile(`^Applying patch (.+) with \d+ rejects?\.\.\.$`)
+	hunk := regexp.MustCompile(`^Rejected hunk #(\d+)\.$`)
+	path := ""
+	for _, line := range strings.Split(string(output), "\n") {
+		m := applying.FindStringSubmatch(line)
+		if m != nil {
+			path = m[1]
+			continue
+		}
+		m = hunk.FindStringSubmatch(line)
+		if m != nil {
+			rejected = append(
+				rejected,
+				Reject{
+					Path: path,
+					Hunk: "#" + m[1],
+				})
+		}
+	}
+	return
+}
+
 // URL returns the parsed URL.
 func (r *Git) URL() (u GitURL) {
 	u = GitURL{}
@@ -137,35 +501,47 @@ func (r *Git) URL() (u GitURL) {
 }
 
 // writeConfig writes config file.
+// The file is scoped to the repository (GIT_CONFIG_GLOBAL).
 func (r *Git) writeConfig() (err error) {
-	path := pathlib.Join(HomeDir, ".gitconfig")
-	found, err := nas.Exists(path)
-	if found || err != nil {
+	home, err := r.home()
+	if err != nil {
 		return
 	}
-	f, err := os.Create(path)
+	policy, err := r.findPolicy(r.Remote.URL, "git.insecure.enabled")
 	if err != nil {
-		err = liberr.Wrap(
-			err,
-			"path",
-			path)
 		return
 	}
-	insecure, err := addon.Setting.Bool("git.insecure.enabled")
+	if policy.Insecure {
+		addon.Activity(
+			"[GIT] TLS verification disabled (%s).",
+			policy.Source)
+	}
+	proxy, err := r.proxy()
 	if err != nil {
 		return
 	}
-	proxy, err := r.proxy()
+	path := pathlib.Join(home, "gitconfig")
+	f, err := os.OpenFile(
+		path,
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC,
+		0600)
 	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			path)
 		return
 	}
 	s := "[user]\n"
 	s += "name = Konveyor Dev\n"
 	s += "email = konveyor-dev@googlegroups.com\n"
 	s += "[credential]\n"
-	s += "helper = store\n"
+	s += fmt.Sprintf(
+		"helper = cache --socket=%s\n",
+		pathlib.Join(home, "credential.sock"))
+	s += "useHttpPath = true\n"
 	s += "[http]\n"
-	s += fmt.Sprintf("sslVerify = %t\n", !insecure)
+	s += fmt.Sprintf("sslVerify = %t\n", !policy.Insecure)
 	if proxy != "" {
 		s += fmt.Sprintf("proxy = %s\n", proxy)
 	}
@@ -181,159 +557,239 @@ func (r *Git) writeConfig() (err error) {
 	return
 }
 
-//
-// writeCreds writes credentials (store) file.
-func (r *Git) writeCreds(id *api.Identity) (err error) {
-	if id.User == "" || id.Password == "" {
+// serveCreds serves the credentials (in memory) to git.
+// The credentials are scoped to the repository host and path.
+// Tokens are sent as the (x-access-token) credential or using
+// the http Authorization header as declared in the identity settings.
+// An identity with a password and no user is a token.
+func (r *Git) serveCreds(id *api.Identity) (err error) {
+	user := id.User
+	password := id.Password
+	settings := IdentitySettings{}
+	err = settings.With(id)
+	if err != nil {
 		return
 	}
-	path := pathlib.Join(HomeDir, ".git-credentials")
-	found, err := nas.Exists(path)
-	if found || err != nil {
+	if settings.Token == nil && user == "" && password != "" {
+		settings.Token = &TokenSettings{}
+	}
+	if settings.Token != nil {
+		var token string
+		token, err = r.token(settings.Token, id)
+		if err != nil {
+			return
+		}
+		if settings.Token.Header {
+			url := r.URL()
+			r.envConfig = append(
+				r.envConfig,
+				fmt.Sprintf(
+					"http.%s://%s/.extraHeader=Authorization: Bearer %s",
+					url.Scheme,
+					url.Address(),
+					token))
+			addon.Activity(
+				"[GIT] Using token (header) for: %s",
+				url.Address())
+			return
+		}
+		user = TokenUser
+		password = token
+	}
+	if user == "" || password == "" {
 		return
 	}
-	f, err := os.Create(path)
+	err = r.startCreds()
 	if err != nil {
-		err = liberr.Wrap(
-			err,
-			"path",
-			path)
 		return
 	}
 	url := r.URL()
-	for _, scheme := range []string{
-		"https",
-		"http",
-	} {
-		entry := scheme
-		entry += "://"
-		if id.User != "" {
-			entry += id.User
-			entry += ":"
-		}
-		if id.Password != "" {
-			entry += id.Password
-			entry += "@"
-		}
-		entry += url.Host
-		_, err = f.Write([]byte(entry + "\n"))
-		if err != nil {
-			err = liberr.Wrap(
-				err,
-				"path",
-				path)
-			break
-		}
+	path := strings.Trim(url.Path, "/")
+	path = strings.TrimSuffix(path, ".git")
+	r.credentials.Add(
+		Credential{
+			Host:     url.Address(),
+			Path:     path,
+			User:     user,
+			Password: password,
+		})
+	addon.Activity(
+		"[GIT] Serving credentials for: %s/%s",
+		url.Address(),
+		path)
+	return
+}
+
+// startCreds starts the credential server.
+func (r *Git) startCreds() (err error) {
+	home, err := r.home()
+	if err != nil {
+		return
 	}
-	_ = f.Close()
-	addon.Activity("[FILE] Created %s.", path)
+	r.credentials.Path = pathlib.Join(home, "credential.sock")
+	err = r.credentials.Start()
+	if err != nil {
+		return
+	}
+	teardown.Func(
+		r.credentials.Path,
+		func() (err error) {
+			r.credentials.Stop()
+			return
+		})
 	return
 }
 
-// proxy builds the proxy.
-func (r *Git) proxy() (proxy string, err error) {
-	kind := ""
+// useCertificate configures the client certificate (mTLS)
+// for the repository URL. Applies only to https.
+// The certificate and key are scoped to the repository host.
+// The key passphrase is served by the credential server.
+func (r *Git) useCertificate() (err error) {
 	url := r.URL()
-	switch url.Scheme {
-	case "http":
-		kind = "http"
-	case "https",
-		"git@github.com":
-		kind = "https"
-	default:
+	if url.Scheme != "https" {
 		return
 	}
-	p, err := addon.Proxy.Find(kind)
-	if err != nil || p == nil || !p.Enabled {
+	cert, found, err := r.findCertificate(r.Repository.URL)
+	if err != nil || !found {
 		return
 	}
-	for _, h := range p.Excluded {
-		if h == url.Host {
-			return
-		}
+	home, err := r.home()
+	if err != nil {
+		return
 	}
-	addon.Activity(
-		"[GIT] Using proxy (%d) %s.",
-		p.ID,
-		p.Kind)
-	auth := ""
-	if p.Identity != nil {
-		var id *api.Identity
-		id, err = addon.Identity.Get(p.Identity.ID)
+	certPath, keyPath, err := cert.WritePEM(home)
+	if err != nil {
+		return
+	}
+	prefix := fmt.Sprintf("http.https://%s/", url.Address())
+	r.envConfig = append(
+		r.envConfig,
+		prefix+".sslCert="+certPath,
+		prefix+".sslKey="+keyPath)
+	if cert.Passphrase != "" {
+		err = r.startCreds()
 		if err != nil {
 			return
 		}
-		auth = fmt.Sprintf(
-			"%s:%s@",
-			id.User,
-			id.Password)
-	}
-	proxy = fmt.Sprintf(
-		"http://%s%s",
-		auth,
-		p.Host)
-	if p.Port > 0 {
-		proxy = fmt.Sprintf(
-			"%s:%d",
-			proxy,
-			p.Port)
+		r.envConfig = append(
+			r.envConfig,
+			prefix+".sslCertPasswordProtected=true")
+		r.credentials.Add(
+			Credential{
+				Protocol: "cert",
+				Path:     certPath,
+				Password: cert.Passphrase,
+			})
 	}
+	addon.Activity(
+		"[GIT] Using client certificate (id=%d) %s for: %s",
+		cert.Identity.ID,
+		cert.Identity.Name,
+		url.Address())
 	return
 }
 
-// checkout ref.
-func (r *Git) checkout() (err error) {
-	branch := r.Remote.Branch
-	if branch == "" {
+// useCA configures the (trusted) CA certificates
+// for the repository URL. Applies only to https.
+func (r *Git) useCA() (err error) {
+	url := r.URL()
+	if url.Scheme != "https" {
 		return
 	}
-	dir, _ := os.Getwd()
-	defer func() {
-		_ = os.Chdir(dir)
-	}()
-	_ = os.Chdir(r.Path)
-	cmd := command.Command{Path: "/usr/bin/git"}
-	cmd.Options.Add("checkout", branch)
-	err = cmd.Run()
+	path, bundle, err := r.writeCA(r.Repository.URL)
+	if err != nil || path == "" {
+		return
+	}
+	r.envConfig = append(
+		r.envConfig,
+		fmt.Sprintf(
+			"http.https://%s/.sslCAInfo=%s",
+			url.Address(),
+			path))
+	addon.Activity(
+		"[GIT] Using CA certificates (%s) for: %s",
+		bundle.Source,
+		url.Address())
 	return
 }
 
-// GitURL git clone URL.
-type GitURL struct {
-	Raw    string
-	Scheme string
-	Host   string
-	Path   string
+// proxy builds the proxy.
+// SOCKS proxies resolve host names using the proxy (socks5h).
+func (r *Git) proxy() (proxy string, err error) {
+	p, err := pxy.Find(r.Remote.URL)
+	if err != nil || p == nil {
+		return
+	}
+	addon.Activity("[GIT] Using proxy %s.", p)
+	u := p.URL()
+	if u.Scheme == "socks5" {
+		u.Scheme = "socks5h"
+	}
+	proxy = u.String()
+	return
 }
 
-// With populates the URL.
-func (r *GitURL) With(u string) (err error) {
-	r.Raw = u
-	parsed, pErr := urllib.Parse(u)
-	if pErr == nil {
-		r.Scheme = parsed.Scheme
-		r.Host = parsed.Host
-		r.Path = parsed.Path
+// useSSH returns the agent used to connect (ssh).
+// Git uses the generated ssh_config (GIT_SSH_COMMAND).
+func (r *Git) useSSH(id *api.Identity) (agent *ssh.Agent, err error) {
+	r.sshCommand = ""
+	url := r.URL()
+	if url.Scheme != "ssh" {
+		agent = &ssh.Agent{}
 		return
 	}
-	notValid := liberr.New("URL not valid.")
-	part := strings.Split(u, ":")
-	if len(part) != 2 {
-		err = notValid
+	agent, err = r.sshAgent(
+		r.Remote.URL,
+		ssh.Host{
+			Pattern: url.Host,
+			Port:    url.Port,
+			User:    url.User,
+		},
+		id)
+	if err != nil {
 		return
 	}
-	r.Host = part[0]
-	r.Path = part[1]
-	part = strings.Split(r.Host, "@")
-	if len(part) != 2 {
-		err = notValid
+	r.sshCommand = agent.Config.Command()
+	return
+}
+
+// checkout ref.
+func (r *Git) checkout() (err error) {
+	branch := r.Remote.Branch
+	if branch == "" {
 		return
 	}
-	r.Host = part[1]
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("checkout", branch)
+	err = cmd.Run()
 	return
 }
 
-// String representation.
-func (r *GitURL) String() string {
-	return r.Raw
+// newCommand returns a git command using the
+// configuration scoped to the repository.
+func (r *Git) newCommand() (cmd command.Command) {
+	cmd = command.Command{Path: "/usr/bin/git"}
+	cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0")
+	if r.sshCommand != "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND="+r.sshCommand)
+	}
+	if r.Home != "" {
+		cmd.Env = append(
+			cmd.Env,
+			"GIT_CONFIG_GLOBAL="+pathlib.Join(r.Home, "gitconfig"))
+	}
+	if len(r.envConfig) > 0 {
+		cmd.Env = append(
+			cmd.Env,
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(r.envConfig)))
+		for i, entry := range r.envConfig {
+			part := strings.SplitN(entry, "=", 2)
+			cmd.Env = append(
+				cmd.Env,
+				fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, part[0]),
+				fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, part[1]))
+		}
+	}
+	return
 }
@@ -0,0 +1,239 @@
+package repository
+
+import (
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-addon/cache"
+	"github.com/konveyor/tackle2-addon/command"
+	"github.com/konveyor/tackle2-hub/api"
+	"gopkg.in/yaml.v2"
+	urllib "net/url"
+	pathlib "path"
+	"strings"
+)
+
+// Identity match ranks.
+const (
+	// NotMatched the identity declares patterns, none matched.
+	NotMatched = iota
+	// DefaultMatch the identity declares no patterns.
+	DefaultMatch
+	// WildcardMatch a (wildcard) host pattern matched.
+	WildcardMatch
+	// HostMatch the host matched exactly.
+	HostMatch
+	// URLMatch a URL pattern matched.
+	URLMatch
+)
+
+// ScopedKinds identity kinds that must declare host or URL
+// patterns. They are never matched by default.
+var ScopedKinds = map[string]bool{
+	CertificateKind: true,
+}
+
+// IdentitySettings (YAML) declared in the identity settings.
+// Not applicable to maven identities (settings.xml).
+// Example:
+//
+//	hosts:
+//	  - github.com
+//	  - "*.example.com"
+//	urls:
+//	  - https://github.com/konveyor/*
+type IdentitySettings struct {
+	// Hosts the identity applies to.
+	// May contain (leading) wildcards.
+	Hosts []string `yaml:"hosts"`
+	// URLs the identity applies to.
+	// May contain wildcards (path.Match).
+	URLs []string `yaml:"urls"`
+	// Token authentication.
+	Token *TokenSettings `yaml:"token"`
+	// Certificate (PEM) client certificate.
+	Certificate string `yaml:"certificate"`
+	// KnownHosts the pinned (ssh) host keys or fingerprints
+	// for the repository host.
+	KnownHosts []string `yaml:"knownHosts"`
+	// SSHCertificate the (OpenSSH) certificate for the key.
+	SSHCertificate string `yaml:"sshCertificate"`
+}
+
+// With populates the settings using the identity.
+// Settings that cannot be parsed are returned as an error
+// and the settings are empty.
+func (r *IdentitySettings) With(id *api.Identity) (err error) {
+	*r = IdentitySettings{}
+	if id.Kind == "maven" || id.Settings == "" {
+		return
+	}
+	err = yaml.Unmarshal([]byte(id.Settings), r)
+	if err != nil {
+		*r = IdentitySettings{}
+		err = liberr.Wrap(
+			err,
+			"identity",
+			id.Name)
+	}
+	return
+}
+
+// Match ranks how the identity matches the host and URL.
+// The reason describes the matched pattern.
+func (r *IdentitySettings) Match(host, url string) (rank int, reason string) {
+	if len(r.Hosts) == 0 && len(r.URLs) == 0 {
+		rank = DefaultMatch
+		reason = "default (no patterns declared)"
+		return
+	}
+	for _, pattern := range r.URLs {
+		if r.matchURL(pattern, url) {
+			rank = URLMatch
+			reason = "URL matched: " + pattern
+			return
+		}
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range r.Hosts {
+		pattern = strings.ToLower(pattern)
+		if pattern == host {
+			rank = HostMatch
+			reason = "host matched: " + pattern
+			return
+		}
+	}
+	for _, pattern := range r.Hosts {
+		pattern = strings.ToLower(pattern)
+		matched, _ := pathlib.Match(pattern, host)
+		if matched {
+			rank = WildcardMatch
+			reason = "host matched: " + pattern
+			return
+		}
+	}
+	return
+}
+
+// matchURL returns true when the URL matches the pattern.
+// The scheme and host (may contain wildcards) must match.
+// The pattern path matches the URL path or leading
+// (whole) segments of the URL path.
+func (r *IdentitySettings) matchURL(pattern, url string) (matched bool) {
+	pScheme, pHost, pPath, parsed := urlParts(pattern)
+	if !parsed {
+		return
+	}
+	scheme, host, path, parsed := urlParts(url)
+	if !parsed || scheme != pScheme {
+		return
+	}
+	matched, _ = pathlib.Match(pHost, host)
+	if !matched {
+		return
+	}
+	if pPath == "" || path == pPath || strings.HasPrefix(path, pPath+"/") {
+		return
+	}
+	matched = false
+	part := strings.Split(path, "/")
+	for i := len(part); i > 0; i-- {
+		matched, _ = pathlib.Match(pPath, strings.Join(part[:i], "/"))
+		if matched {
+			break
+		}
+	}
+	return
+}
+
+// urlParts returns the (normalized) scheme, host[:port] and
+// path of the URL. The path has no leading or trailing slash.
+func urlParts(url string) (scheme, host, path string, parsed bool) {
+	gitURL := GitURL{}
+	err := gitURL.With(url)
+	if err == nil {
+		n := gitURL.Normalize()
+		scheme = n.Scheme
+		host = n.Address()
+		path = n.Path
+	} else {
+		u, pErr := urllib.Parse(url)
+		if pErr != nil || u.Host == "" {
+			return
+		}
+		scheme = strings.ToLower(u.Scheme)
+		host = strings.ToLower(u.Host)
+		path = u.Path
+	}
+	path = strings.Trim(path, "/")
+	parsed = true
+	return
+}
+
+// findIdentity by kind.
+// The identity is selected by (best) match of the
+// patterns declared in the identity settings:
+// URL, exact host, wildcard host and then the default.
+func (r *Remote) findIdentity(kind string) (matched *api.Identity, found bool, err error) {
+	url := ""
+	if r.Repository != nil {
+		url = r.URL
+	}
+	matched, found, err = r.findIdentityFor(kind, url)
+	return
+}
+
+// findIdentityFor finds the identity by kind for the URL.
+// See: findIdentity().
+func (r *Remote) findIdentityFor(kind, url string) (matched *api.Identity, found bool, err error) {
+	host := hostOf(url)
+	err = cache.Identity.Prefetch(r.Identities)
+	if err != nil {
+		return
+	}
+	best := NotMatched
+	reason := ""
+	for _, ref := range r.Identities {
+		identity, nErr := cache.Identity.Get(ref.ID)
+		if nErr != nil {
+			err = nErr
+			return
+		}
+		if identity.Kind != kind {
+			continue
+		}
+		settings := IdentitySettings{}
+		sErr := settings.With(identity)
+		if sErr != nil {
+			addon.Activity(
+				"[SCM] Identity (id=%d) %s ignored: settings not valid: %s",
+				identity.ID,
+				identity.Name,
+				sErr.Error())
+			continue
+		}
+		rank, why := settings.Match(host, url)
+		if rank == DefaultMatch && ScopedKinds[kind] {
+			addon.Activity(
+				"[SCM] Identity (id=%d) %s ignored: hosts or urls required (kind=%s).",
+				identity.ID,
+				identity.Name,
+				kind)
+			continue
+		}
+		if rank > best {
+			best = rank
+			reason = why
+			matched = identity
+		}
+	}
+	if matched != nil {
+		found = true
+		command.Secret(matched.Password, matched.Key)
+		addon.Activity(
+			"[SCM] Identity (id=%d) %s selected for %s: %s.",
+			matched.ID,
+			matched.Name,
+			host,
+			reason)
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"testing"
+)
+
+func TestMatchURL(t *testing.T) {
+	cases := []struct {
+		pattern string
+		url     string
+		matched bool
+	}{
+		// host.
+		{pattern: "https://git.example.com", url: "https://git.example.com/org/repo", matched: true},
+		{pattern: "https://git.example.com/", url: "https://GIT.example.com/org/repo.git", matched: true},
+		{pattern: "https://git.example.com", url: "https://git.example.com.evil.org/org/repo"},
+		{pattern: "https://git.example.com", url: "https://git.example.com:8443/org/repo"},
+		{pattern: "https://git.example.com:8443", url: "https://git.example.com:8443/org/repo", matched: true},
+		{pattern: "https://*.example.com", url: "https://git.example.com/org/repo", matched: true},
+		{pattern: "https://*.example.com", url: "https://git.example.com.evil.org/org/repo"},
+		// scheme.
+		{pattern: "https://github.com/konveyor", url: "http://github.com/konveyor/repo"},
+		{pattern: "ssh://github.com/konveyor", url: "git@github.com:konveyor/repo.git", matched: true},
+		{pattern: "svn://svn.example.com/repo", url: "svn://svn.example.com/repo/trunk", matched: true},
+		// path.
+		{pattern: "https://github.com/konveyor", url: "https://github.com/konveyor", matched: true},
+		{pattern: "https://github.com/konveyor", url: "https://github.com/konveyor/repo", matched: true},
+		{pattern: "https://github.com/konveyor", url: "https://github.com/konveyor-evil/repo"},
+		{pattern: "https://github.com/konveyor/*", url: "https://github.com/konveyor/repo", matched: true},
+		{pattern: "https://github.com/konveyor/*", url: "https://github.com/konveyor/repo/sub", matched: true},
+		{pattern: "https://github.com/konveyor/*", url: "https://github.com/konveyor-evil/repo"},
+		{pattern: "https://github.com/*/repo", url: "https://github.com/org/repo", matched: true},
+		{pattern: "https://github.com/*/repo", url: "https://github.com/org/repo-evil"},
+		// not valid.
+		{pattern: "github.com", url: "https://github.com/org/repo"},
+		{pattern: "https://github.com", url: ""},
+	}
+	for _, c := range cases {
+		settings := IdentitySettings{}
+		matched := settings.matchURL(c.pattern, c.url)
+		if matched != c.matched {
+			t.Errorf(
+				"matchURL(%q, %q) = %t, expected: %t",
+				c.pattern,
+				c.url,
+				matched,
+				c.matched)
+		}
+	}
+}
@@ -1,13 +1,24 @@
 package repository
 
 import (
+	"crypto/tls"
+	"fmt"
 	"github.com/clbanning/mxj"
 	liberr "github.com/jortel/go-utils/error"
 	"github.com/konveyor/tackle2-addon/command"
+	pxy "github.com/konveyor/tackle2-addon/proxy"
 	"github.com/konveyor/tackle2-hub/api"
 	"github.com/konveyor/tackle2-hub/nas"
+	"net/http"
+	urllib "net/url"
 	"os"
 	pathlib "path"
+	"strings"
+	"time"
+)
+
+const (
+	MavenCentral = "https://repo.maven.apache.org/maven2/"
 )
 
 //
@@ -97,6 +108,166 @@ func (r *Maven) HasModules(sourceDir string) (found bool, err error) {
 	return
 }
 
+//
+// Verify verifies the maven repositories (defined in the settings
+// or maven central) are reachable and the credentials accepted.
+func (r *Maven) Verify() (result *Verification, err error) {
+	repositories, err := r.remotes()
+	if err != nil {
+		return
+	}
+	for _, repository := range repositories {
+		addon.Activity("[MVN] Verifying: %s", command.Redact(repository.URL))
+		result, err = r.verify(repository)
+		if err != nil || !result.RefExists {
+			break
+		}
+	}
+	return
+}
+
+//
+// verify sends a HEAD request to the repository.
+// The request is sent using the TLS policy, CA certificates,
+// client certificate (mTLS) and proxy for the repository.
+func (r *Maven) verify(repository MavenRepository) (result *Verification, err error) {
+	result = &Verification{}
+	u, err := urllib.Parse(repository.URL)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	transport, err := r.httpTransport(repository.URL, "mvn.insecure.enabled")
+	if err != nil {
+		return
+	}
+	cert, found, err := r.findCertificate(repository.URL)
+	if err != nil {
+		return
+	}
+	if found {
+		var tlsCert tls.Certificate
+		tlsCert, err = cert.TLSCertificate()
+		if err != nil {
+			return
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	client := http.Client{
+		Transport: transport,
+		Timeout:   time.Minute,
+	}
+	request, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	if repository.User != "" {
+		request.SetBasicAuth(repository.User, repository.Password)
+	}
+	response, rErr := client.Do(request)
+	if rErr != nil {
+		result.Error = command.Redact(rErr.Error())
+		return
+	}
+	_ = response.Body.Close()
+	result.Reachable = true
+	switch response.StatusCode {
+	case http.StatusUnauthorized,
+		http.StatusForbidden,
+		http.StatusProxyAuthRequired:
+		result.Error = response.Status
+	case http.StatusNotFound:
+		result.Authenticated = true
+		result.Error = response.Status
+	default:
+		result.Authenticated = true
+		result.RefExists = true
+	}
+	return
+}
+
+//
+// remotes returns the repositories defined in the
+// settings or maven central.
+func (r *Maven) remotes() (repositories []MavenRepository, err error) {
+	repositories = []MavenRepository{
+		{
+			ID:  "central",
+			URL: MavenCentral,
+		},
+	}
+	id, found, err := r.findIdentity("maven")
+	if err != nil {
+		return
+	}
+	if found {
+		var defined []MavenRepository
+		defined, err = r.repositories(id.Settings)
+		if err != nil {
+			return
+		}
+		if len(defined) > 0 {
+			repositories = defined
+		}
+	}
+	return
+}
+
+//
+// repositories returns the mirrors and repositories defined
+// in the settings with the credentials of the matching server.
+func (r *Maven) repositories(settings string) (list []MavenRepository, err error) {
+	m, err := mxj.NewMapXml([]byte(settings))
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	servers := make(map[string]MavenRepository)
+	v, err := m.ValuesForPath("settings.servers.server")
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	for _, server := range v {
+		mp, cast := server.(map[string]interface{})
+		if !cast {
+			continue
+		}
+		repository := MavenRepository{}
+		repository.With(mp)
+		command.Secret(repository.Password)
+		servers[repository.ID] = repository
+	}
+	for _, path := range []string{
+		"settings.mirrors.mirror",
+		"settings.profiles.profile.repositories.repository",
+	} {
+		v, err = m.ValuesForPath(path)
+		if err != nil {
+			err = liberr.Wrap(err)
+			return
+		}
+		for _, defined := range v {
+			mp, cast := defined.(map[string]interface{})
+			if !cast {
+				continue
+			}
+			repository := MavenRepository{}
+			repository.With(mp)
+			if repository.URL == "" {
+				continue
+			}
+			if server, found := servers[repository.ID]; found {
+				repository.User = server.User
+				repository.Password = server.Password
+			}
+			list = append(list, repository)
+		}
+	}
+	return
+}
+
 //
 // run executes maven.
 func (r *Maven) run(options command.Options) (err error) {
@@ -104,7 +275,7 @@ func (r *Maven) run(options command.Options) (err error) {
 	if err != nil {
 		return
 	}
-	insecure, err := addon.Setting.Bool("mvn.insecure.enabled")
+	insecure, err := r.insecure()
 	if err != nil {
 		return
 	}
@@ -122,12 +293,272 @@ func (r *Maven) run(options command.Options) (err error) {
 	if settings != "" {
 		cmd.Options.Add("-s", settings)
 	}
+	keyStore, err := r.keyStore()
+	if err != nil {
+		return
+	}
+	trustStore, err := r.trustStore()
+	if err != nil {
+		return
+	}
+	socks, err := r.socks()
+	if err != nil {
+		return
+	}
+	jvm := append(keyStore, trustStore...)
+	jvm = append(jvm, socks...)
+	if len(jvm) > 0 {
+		cmd.Env = append(
+			cmd.Env,
+			"MAVEN_OPTS="+strings.Join(
+				append(
+					[]string{os.Getenv("MAVEN_OPTS")},
+					jvm...),
+				" "))
+	}
 	err = cmd.Run()
 	return
 }
 
+//
+// socks returns the (JVM) options for the SOCKS proxy.
+// The SOCKS proxy is JVM-wide and cannot be defined
+// in the settings (proxies).
+func (r *Maven) socks() (options []string, err error) {
+	proxies, err := pxy.List()
+	if err != nil {
+		return
+	}
+	for i := range proxies {
+		p := &proxies[i]
+		if !p.SOCKS() {
+			continue
+		}
+		addon.Activity("[MVN] Using proxy %s.", p)
+		options = append(
+			options,
+			"-DsocksProxyHost="+p.Host)
+		if p.Port > 0 {
+			options = append(
+				options,
+				fmt.Sprintf("-DsocksProxyPort=%d", p.Port))
+		}
+		if p.User != "" {
+			options = append(
+				options,
+				"-Djava.net.socks.username="+p.User,
+				"-Djava.net.socks.password="+p.Password)
+		}
+		patterns := p.Patterns()
+		if len(patterns) > 0 {
+			options = append(
+				options,
+				"-DsocksNonProxyHosts="+strings.Join(patterns, "|"))
+		}
+		break
+	}
+	return
+}
+
+//
+// insecure returns true when TLS verification is skipped for
+// any of the repositories. Maven TLS verification is JVM-wide.
+func (r *Maven) insecure() (insecure bool, err error) {
+	repositories, err := r.remotes()
+	if err != nil {
+		return
+	}
+	for _, repository := range repositories {
+		var policy *TLSPolicy
+		policy, err = r.policy(repository)
+		if err != nil {
+			return
+		}
+		if policy.Insecure {
+			addon.Activity(
+				"[MVN] TLS verification disabled (%s).",
+				policy.Source)
+			insecure = true
+		}
+	}
+	return
+}
+
+//
+// policy returns the TLS policy for the repository.
+// The repository URL must be permitted by the policy.
+func (r *Maven) policy(repository MavenRepository) (policy *TLSPolicy, err error) {
+	u, err := urllib.Parse(repository.URL)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	policy, err = r.findPolicy(repository.URL, "mvn.insecure.enabled")
+	if err != nil {
+		return
+	}
+	err = policy.Validate(u.Scheme, command.Redact(repository.URL))
+	return
+}
+
+//
+// keyStore returns the (JVM) options for the client certificate (mTLS)
+// matching the repositories. The keystore is JVM-wide and applies to
+// all repositories. The first matched certificate is used.
+func (r *Maven) keyStore() (options []string, err error) {
+	repositories, err := r.remotes()
+	if err != nil {
+		return
+	}
+	var cert *ClientCertificate
+	for _, repository := range repositories {
+		var found bool
+		cert, found, err = r.findCertificate(repository.URL)
+		if err != nil {
+			return
+		}
+		if found {
+			break
+		}
+	}
+	if cert == nil {
+		return
+	}
+	home, err := r.home()
+	if err != nil {
+		return
+	}
+	path, password, err := cert.WritePKCS12(home)
+	if err != nil {
+		return
+	}
+	addon.Activity(
+		"[MVN] Using client certificate (id=%d) %s.",
+		cert.Identity.ID,
+		cert.Identity.Name)
+	options = []string{
+		"-Djavax.net.ssl.keyStore=" + path,
+		"-Djavax.net.ssl.keyStoreType=PKCS12",
+		"-Djavax.net.ssl.keyStorePassword=" + password,
+	}
+	return
+}
+
+//
+// trustStore returns the (JVM) options for the truststore containing
+// the (trusted) CA certificates matching the repositories. The truststore
+// is JVM-wide so it contains the JVM (cacerts) CA certificates followed by
+// the CA certificates for all of the repositories.
+func (r *Maven) trustStore() (options []string, err error) {
+	bundles, err := r.bundles()
+	if err != nil || len(bundles) == 0 {
+		return
+	}
+	home, err := r.home()
+	if err != nil {
+		return
+	}
+	password, err := newPassword()
+	if err != nil {
+		return
+	}
+	path := pathlib.Join(home, "truststore.p12")
+	_ = os.Remove(path)
+	env := "STORE_PASSWORD=" + password
+	for _, cacerts := range JavaCACerts() {
+		_, err = os.Stat(cacerts)
+		if err != nil {
+			continue
+		}
+		cmd := command.Command{Path: "/usr/bin/keytool"}
+		cmd.Options.Add("-importkeystore", "-noprompt")
+		cmd.Options.Add("-srckeystore", cacerts)
+		cmd.Options.Add("-srcstorepass", "changeit")
+		cmd.Options.Add("-destkeystore", path)
+		cmd.Options.Add("-deststoretype", "PKCS12")
+		cmd.Options.Add("-deststorepass:env", "STORE_PASSWORD")
+		cmd.Env = append(cmd.Env, env)
+		err = cmd.Run()
+		if err != nil {
+			return
+		}
+		break
+	}
+	n := 0
+	for _, bundle := range bundles {
+		addon.Activity(
+			"[MVN] Using CA certificates (%s).",
+			bundle.Source)
+		for _, certificate := range bundle.Split() {
+			crt := pathlib.Join(home, fmt.Sprintf("ca-%d.crt", n))
+			err = writeFile(crt, certificate)
+			if err != nil {
+				return
+			}
+			cmd := command.Command{Path: "/usr/bin/keytool"}
+			cmd.Options.Add("-importcert", "-noprompt", "-trustcacerts")
+			cmd.Options.Add("-alias", fmt.Sprintf("konveyor-ca-%d", n))
+			cmd.Options.Add("-file", crt)
+			cmd.Options.Add("-keystore", path)
+			cmd.Options.Add("-storetype", "PKCS12")
+			cmd.Options.Add("-storepass:env", "STORE_PASSWORD")
+			cmd.Env = append(cmd.Env, env)
+			err = cmd.Run()
+			if err != nil {
+				return
+			}
+			n++
+		}
+	}
+	options = []string{
+		"-Djavax.net.ssl.trustStore=" + path,
+		"-Djavax.net.ssl.trustStoreType=PKCS12",
+		"-Djavax.net.ssl.trustStorePassword=" + password,
+	}
+	return
+}
+
+//
+// bundles returns the (distinct) CA bundles matching the repositories.
+func (r *Maven) bundles() (bundles []*CABundle, err error) {
+	repositories, err := r.remotes()
+	if err != nil {
+		return
+	}
+	matched := make(map[string]bool)
+	for _, repository := range repositories {
+		bundle, found, fErr := r.findCA(repository.URL)
+		if fErr != nil {
+			err = fErr
+			return
+		}
+		if found && !matched[bundle.Source] {
+			matched[bundle.Source] = true
+			bundles = append(bundles, bundle)
+		}
+	}
+	return
+}
+
+//
+// JavaCACerts returns the candidate JVM (cacerts) truststore paths.
+func JavaCACerts() (paths []string) {
+	home := os.Getenv("JAVA_HOME")
+	if home != "" {
+		paths = append(
+			paths,
+			pathlib.Join(home, "lib", "security", "cacerts"))
+	}
+	paths = append(
+		paths,
+		"/etc/pki/java/cacerts",
+		"/etc/ssl/certs/java/cacerts")
+	return
+}
+
 //
 // writeSettings writes settings file.
+// The file is scoped to the repository.
 func (r *Maven) writeSettings() (path string, err error) {
 	id, found, err := r.findIdentity("maven")
 	if err != nil {
@@ -141,13 +572,19 @@ func (r *Maven) writeSettings() (path string, err error) {
 	} else {
 		return
 	}
-	dir, _ := os.Getwd()
-	path = pathlib.Join(dir, "settings.xml")
-	found, err = nas.Exists(path)
-	if found || err != nil {
+	home, err := r.home()
+	if err != nil {
+		return
+	}
+	settings, err := r.injectProxy(id)
+	if err != nil {
 		return
 	}
-	f, err := os.Create(path)
+	path = pathlib.Join(home, "settings.xml")
+	f, err := os.OpenFile(
+		path,
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC,
+		0600)
 	if err != nil {
 		err = liberr.Wrap(
 			err,
@@ -155,11 +592,6 @@ func (r *Maven) writeSettings() (path string, err error) {
 			path)
 		return
 	}
-	settings := id.Settings
-	settings, err = r.injectProxy(id)
-	if err != nil {
-		return
-	}
 	_, err = f.Write([]byte(settings))
 	if err != nil {
 		err = liberr.Wrap(
@@ -181,34 +613,30 @@ func (r *Maven) injectProxy(id *api.Identity) (s string, err error) {
 		err = liberr.Wrap(err)
 		return
 	}
-	proxies, err := addon.Proxy.List()
+	proxies, err := pxy.List()
 	if err != nil {
 		return
 	}
 	pList := []interface{}{}
 	for _, p := range proxies {
-		if !p.Enabled {
+		if p.SOCKS() {
 			continue
 		}
-		addon.Activity(
-			"[MVN] Using proxy (%d) %s.",
-			p.ID,
-			p.Kind)
+		addon.Activity("[MVN] Using proxy %s.", &p)
 		mp := mxj.Map{
 			"id":       p.Kind,
-			"active":   p.Enabled,
+			"active":   true,
 			"protocol": p.Kind,
 			"host":     p.Host,
 			"port":     p.Port,
 		}
-		if p.Identity != nil {
-			pid, idErr := addon.Identity.Get(p.Identity.ID)
-			if idErr != nil {
-				err = idErr
-				return
-			}
-			mp["username"] = pid.User
-			mp["password"] = pid.Password
+		if p.User != "" {
+			mp["username"] = p.User
+			mp["password"] = p.Password
+		}
+		patterns := p.Patterns()
+		if len(patterns) > 0 {
+			mp["nonProxyHosts"] = strings.Join(patterns, "|")
 		}
 		pList = append(pList, mp)
 	}
@@ -231,3 +659,21 @@ func (r *Maven) injectProxy(id *api.Identity) (s string, err error) {
 	s = string(b)
 	return
 }
+
+//
+// MavenRepository a maven (remote) repository.
+type MavenRepository struct {
+	ID       string
+	URL      string
+	User     string
+	Password string
+}
+
+//
+// With populates the repository with a settings (XML) element.
+func (r *MavenRepository) With(m mxj.Map) {
+	r.ID, _ = m.ValueForPathString("id")
+	r.URL, _ = m.ValueForPathString("url")
+	r.User, _ = m.ValueForPathString("username")
+	r.Password, _ = m.ValueForPathString("password")
+}
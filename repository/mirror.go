@@ -0,0 +1,73 @@
+package repository
+
+import (
+	urllib "net/url"
+)
+
+const (
+	// MirrorSetting the (alternate) mirror URLs setting key.
+	// The value is a map of host to the ordered list of mirror URLs.
+	MirrorSetting = "scm.url.mirrors"
+)
+
+// Mirrors returns the mirror URLs defined in the settings for the host.
+func Mirrors(host string) (urls []string, err error) {
+	mirrors := make(map[string][]string)
+	_, err = setting(MirrorSetting, &mirrors)
+	if err != nil {
+		return
+	}
+	urls = mirrors[host]
+	return
+}
+
+// findMirrors populates the mirrors defined in the settings.
+// The URL rewrite rules are applied to the mirror URLs.
+func (r *Remote) findMirrors() (err error) {
+	if r.Repository == nil || r.URL == "" {
+		return
+	}
+	urls, err := Mirrors(r.host())
+	if err != nil {
+		return
+	}
+	rules, err := RewriteRules()
+	if err != nil {
+		return
+	}
+	for _, url := range urls {
+		r.Mirrors = append(r.Mirrors, Rewrite(rules, url))
+	}
+	return
+}
+
+// urls returns the URL followed by the mirror URLs.
+func (r *Remote) urls() (urls []string) {
+	urls = append(urls, r.URL)
+	urls = append(urls, r.Mirrors...)
+	return
+}
+
+// host returns the host of the remote URL.
+func (r *Remote) host() (host string) {
+	if r.Repository == nil {
+		return
+	}
+	host = hostOf(r.URL)
+	return
+}
+
+// hostOf returns the host of the URL.
+func hostOf(url string) (host string) {
+	u := GitURL{}
+	err := u.With(url)
+	if err == nil {
+		host = u.Host
+		return
+	}
+	parsed, err := urllib.Parse(url)
+	if err == nil {
+		host = parsed.Hostname()
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-addon/command"
+	pxy "github.com/konveyor/tackle2-addon/proxy"
+	"net/http"
+	urllib "net/url"
+)
+
+const (
+	// PolicySetting the per-host TLS policy setting key.
+	// The value is a map of host pattern to TLSPolicy.
+	// The (*) pattern is the default for all hosts.
+	PolicySetting = "tls.host.policy"
+)
+
+// TLSPolicy per-host TLS policy.
+// When no host policy matches, the (global) insecure
+// setting for the tool applies to all hosts.
+// Example:
+//
+//	{
+//	  "lab.example.com": {"allowHttp": true},
+//	  "*.test.example.com": {"insecure": true},
+//	  "git.example.com": {"ca": "-----BEGIN CERTIFICATE-----..."}
+//	}
+type TLSPolicy struct {
+	// Source describes where the policy is defined.
+	Source string `json:"-"`
+	// AllowHTTP permits (plain) http URLs.
+	AllowHTTP bool `json:"allowHttp"`
+	// Insecure skips TLS verification.
+	Insecure bool `json:"insecure"`
+	// CA trusted CA certificates (PEM).
+	CA string `json:"ca"`
+}
+
+// Validate the URL scheme is permitted.
+func (r *TLSPolicy) Validate(scheme, url string) (err error) {
+	if scheme == "http" && !r.AllowHTTP {
+		err = liberr.New(
+			"http URL not permitted by TLS policy.",
+			"url",
+			url,
+			"policy",
+			r.Source)
+	}
+	return
+}
+
+// Policies returns the TLS policies defined in the settings.
+func Policies() (policies map[string]TLSPolicy, err error) {
+	policies = make(map[string]TLSPolicy)
+	_, err = setting(PolicySetting, &policies)
+	return
+}
+
+// findPolicy finds the TLS policy for the URL.
+// The best match of the host patterns is selected: exact,
+// wildcard and then (*). When not matched, the (global) insecure
+// setting permits http and skips TLS verification for all hosts.
+func (r *Remote) findPolicy(url, insecureSetting string) (policy *TLSPolicy, err error) {
+	policies, err := Policies()
+	if err != nil {
+		return
+	}
+	var patterns []string
+	for pattern := range policies {
+		patterns = append(patterns, pattern)
+	}
+	pattern, found := bestMatch(patterns, url)
+	if found {
+		matched := policies[pattern]
+		matched.Source = "host: " + pattern
+		policy = &matched
+		return
+	}
+	insecure, err := addon.Setting.Bool(insecureSetting)
+	if err != nil {
+		return
+	}
+	policy = &TLSPolicy{
+		Source:    "setting: " + insecureSetting,
+		AllowHTTP: insecure,
+		Insecure:  insecure,
+	}
+	return
+}
+
+// httpTransport returns the http transport for the URL.
+// Uses the TLS policy, CA certificates and the proxy for the URL.
+func (r *Remote) httpTransport(url, insecureSetting string) (transport *http.Transport, err error) {
+	u, err := urllib.Parse(url)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	policy, err := r.findPolicy(url, insecureSetting)
+	if err != nil {
+		return
+	}
+	err = policy.Validate(u.Scheme, command.Redact(url))
+	if err != nil {
+		return
+	}
+	transport = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: policy.Insecure,
+		},
+	}
+	bundle, found, err := r.findCA(url)
+	if err != nil {
+		return
+	}
+	if found {
+		pool, pErr := x509.SystemCertPool()
+		if pErr != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM([]byte(bundle.Certificates))
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	p, err := pxy.Find(url)
+	if err != nil {
+		return
+	}
+	if p != nil {
+		proxy := p.URL()
+		transport.Proxy = func(*http.Request) (*urllib.URL, error) {
+			return proxy, nil
+		}
+	}
+	return
+}
+
+// bestMatch returns the host pattern that best matches the URL:
+// exact, wildcard and then (*).
+func bestMatch(patterns []string, url string) (matched string, found bool) {
+	host := hostOf(url)
+	best := NotMatched
+	for _, pattern := range patterns {
+		rank := DefaultMatch
+		if pattern != "*" {
+			settings := IdentitySettings{Hosts: []string{pattern}}
+			rank, _ = settings.Match(host, url)
+		}
+		if rank > best || (rank == best && rank > NotMatched && pattern < matched) {
+			best = rank
+			matched = pattern
+			found = true
+		}
+	}
+	return
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"github.com/konveyor/tackle2-addon/command"
+	"strings"
+)
+
+const (
+	// RewriteSetting the URL rewrite rules setting key.
+	RewriteSetting = "scm.url.rewrite"
+)
+
+// RewriteRule URL rewrite rule.
+// Like git url.<base>.insteadOf, URLs beginning with
+// the prefix are rewritten to begin with the replacement.
+type RewriteRule struct {
+	Prefix      string `json:"prefix"`
+	Replacement string `json:"replacement"`
+}
+
+// Match returns true when the URL begins with the prefix.
+func (r *RewriteRule) Match(url string) (matched bool) {
+	matched = r.Prefix != "" && strings.HasPrefix(url, r.Prefix)
+	return
+}
+
+// Apply the rule to the URL.
+func (r *RewriteRule) Apply(url string) (rewritten string) {
+	rewritten = r.Replacement + strings.TrimPrefix(url, r.Prefix)
+	return
+}
+
+// RewriteRules returns the URL rewrite rules defined in the settings.
+func RewriteRules() (rules []RewriteRule, err error) {
+	_, err = setting(RewriteSetting, &rules)
+	return
+}
+
+// Rewrite returns the URL rewritten using the longest
+// matching prefix in the rules.
+func Rewrite(rules []RewriteRule, url string) (rewritten string) {
+	rewritten = url
+	var matched *RewriteRule
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Match(url) {
+			continue
+		}
+		if matched == nil || len(rule.Prefix) > len(matched.Prefix) {
+			matched = rule
+		}
+	}
+	if matched != nil {
+		rewritten = matched.Apply(url)
+	}
+	return
+}
+
+// rewrite applies the URL rewrite rules to the remote URL.
+func (r *Remote) rewrite() (err error) {
+	if r.Repository == nil || r.URL == "" {
+		return
+	}
+	rules, err := RewriteRules()
+	if err != nil {
+		return
+	}
+	rewritten := Rewrite(rules, r.URL)
+	if rewritten != r.URL {
+		addon.Activity(
+			"[SCM] URL rewritten: %s => %s",
+			command.Redact(r.URL),
+			command.Redact(rewritten))
+		r.URL = rewritten
+	}
+	return
+}
@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"github.com/konveyor/tackle2-addon/cache"
+	"github.com/konveyor/tackle2-addon/command"
+	pxy "github.com/konveyor/tackle2-addon/proxy"
+	"github.com/konveyor/tackle2-addon/ssh"
+	"github.com/konveyor/tackle2-hub/api"
+	"net"
+	pathlib "path"
+	"strconv"
+)
+
+const (
+	// BastionSetting the (ssh) bastion setting key.
+	// The value is a map of host pattern to Bastion.
+	// The (*) pattern is the default for all hosts.
+	BastionSetting = "ssh.bastion"
+	// SSHProxySetting enables ssh using the (https)
+	// proxy (HTTP CONNECT). Default: true.
+	// The SOCKS proxy is always used.
+	SSHProxySetting = "ssh.proxy.connect.enabled"
+	// SSHTimeoutSetting the ssh connect timeout (seconds) setting key.
+	SSHTimeoutSetting = "ssh.connect.timeout"
+	// SSHTimeout the default ssh connect timeout (seconds).
+	SSHTimeout = 30
+)
+
+// Bastion an ssh jump host.
+// Example:
+//
+//	{
+//	  "*.internal.example.com": {
+//	    "host": "bastion.example.com",
+//	    "user": "git",
+//	    "identity": 12
+//	  }
+//	}
+type Bastion struct {
+	// Host the bastion host.
+	Host string `json:"host"`
+	// Port the bastion port.
+	Port int `json:"port"`
+	// User the bastion user.
+	User string `json:"user"`
+	// Identity the ID of the identity containing the bastion key.
+	Identity uint `json:"identity"`
+}
+
+// Address returns host[:port].
+func (r *Bastion) Address() (address string) {
+	address = r.Host
+	if r.Port > 0 {
+		address = net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+	}
+	return
+}
+
+// findBastion finds the bastion for the URL.
+func (r *Remote) findBastion(url string) (bastion *Bastion, err error) {
+	bastions := make(map[string]Bastion)
+	_, err = setting(BastionSetting, &bastions)
+	if err != nil {
+		return
+	}
+	var patterns []string
+	for pattern := range bastions {
+		patterns = append(patterns, pattern)
+	}
+	pattern, found := bestMatch(patterns, url)
+	if found {
+		matched := bastions[pattern]
+		bastion = &matched
+	}
+	return
+}
+
+// sshAgent returns the agent used to connect to the target host.
+// The agent is configured with the generated ssh_config containing
+// a Host block for the target (and bastion) host. Each block offers
+// only the key for the identity (IdentitiesOnly) and requires the
+// host key to be known. The bastion key is added to the agent and
+// the bastion host key scanned.
+func (r *Remote) sshAgent(url string, target ssh.Host, id *api.Identity) (agent *ssh.Agent, err error) {
+	home, err := r.home()
+	if err != nil {
+		return
+	}
+	config := &ssh.Config{
+		Path: pathlib.Join(home, "ssh_config"),
+	}
+	agent = &ssh.Agent{
+		Config: config,
+		Pins:   ssh.Pins{},
+	}
+	timeout, err := r.sshTimeout()
+	if err != nil {
+		return
+	}
+	bastion, err := r.findBastion(url)
+	if err != nil {
+		return
+	}
+	var bastionId *api.Identity
+	if bastion != nil {
+		jump := ssh.Host{
+			Pattern: bastion.Host,
+			User:    bastion.User,
+		}
+		if bastion.Port > 0 {
+			jump.Port = strconv.Itoa(bastion.Port)
+		}
+		jump.ProxyCommand, err = r.sshProxy(bastion.Host, jump.Port)
+		if err != nil {
+			return
+		}
+		if bastion.Identity > 0 {
+			bastionId, err = cache.Identity.Get(bastion.Identity)
+			if err != nil {
+				return
+			}
+			command.Secret(bastionId.Password, bastionId.Key)
+		}
+		r.sshHost(&jump, bastionId, timeout)
+		r.sshPins(agent, bastion.Address(), bastionId)
+		config.Add(jump)
+		target.ProxyJump = bastion.Address()
+		if bastion.User != "" {
+			target.ProxyJump = bastion.User + "@" + target.ProxyJump
+		}
+		addon.Activity(
+			"[SSH] Using bastion %s for: %s",
+			bastion.Address(),
+			target.Pattern)
+	} else {
+		target.ProxyCommand, err = r.sshProxy(target.Pattern, target.Port)
+		if err != nil {
+			return
+		}
+	}
+	r.sshHost(&target, id, timeout)
+	address := target.Pattern
+	if target.Port != "" {
+		address = net.JoinHostPort(target.Pattern, target.Port)
+	}
+	r.sshPins(agent, address, id)
+	config.Add(target)
+	err = config.Write()
+	if err != nil {
+		return
+	}
+	if bastionId != nil && bastionId.Key != "" {
+		err = agent.Add(bastionId, bastion.Address())
+	} else if bastion != nil {
+		err = agent.Scan(bastion.Address())
+	}
+	return
+}
+
+// sshHost populates the (common) host block options.
+func (r *Remote) sshHost(host *ssh.Host, id *api.Identity, timeout int) {
+	if id != nil && id.Key != "" {
+		host.IdentityFile = ssh.KeyPath(id.ID)
+		host.IdentitiesOnly = true
+		if host.User == "" {
+			host.User = id.User
+		}
+		settings := IdentitySettings{}
+		settings.With(id)
+		if settings.SSHCertificate != "" {
+			host.CertificateFile = ssh.CertificatePath(id.ID)
+		}
+	}
+	host.StrictHostKeyChecking = "yes"
+	host.UserKnownHostsFile = ssh.KnownHosts
+	host.ConnectTimeout = timeout
+}
+
+// sshPins adds the host keys pinned in the identity settings.
+func (r *Remote) sshPins(agent *ssh.Agent, host string, id *api.Identity) {
+	if id == nil {
+		return
+	}
+	settings := IdentitySettings{}
+	settings.With(id)
+	agent.Pins.Add(host, settings.KnownHosts...)
+}
+
+// sshTimeout returns the ssh connect timeout (seconds).
+func (r *Remote) sshTimeout() (timeout int, err error) {
+	timeout = SSHTimeout
+	_, err = setting(SSHTimeoutSetting, &timeout)
+	return
+}
+
+// sshProxy returns the ssh ProxyCommand used to connect to the host.
+// Empty when not proxied. The proxy is skipped (logged) and the
+// host connected directly when the ProxyCommand cannot be built.
+func (r *Remote) sshProxy(host, port string) (proxyCommand string, err error) {
+	if port == "" {
+		port = "22"
+	}
+	connect := true
+	_, err = setting(SSHProxySetting, &connect)
+	if err != nil {
+		return
+	}
+	p, err := pxy.FindSSH(host, port)
+	if err != nil || p == nil {
+		return
+	}
+	if !p.SOCKS() && !connect {
+		addon.Activity(
+			"[SSH] Proxy %s skipped (%s=false) for: %s",
+			p,
+			SSHProxySetting,
+			net.JoinHostPort(host, port))
+		return
+	}
+	proxyCommand, pErr := ssh.ProxyCommand(p)
+	if pErr != nil {
+		addon.Activity(
+			"[SSH] Proxy %s skipped for: %s (connecting directly): %s",
+			p,
+			net.JoinHostPort(host, port),
+			pErr.Error())
+		return
+	}
+	addon.Activity(
+		"[SSH] Using proxy %s for: %s",
+		p,
+		net.JoinHostPort(host, port))
+	return
+}
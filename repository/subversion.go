@@ -1,10 +1,12 @@
 package repository
 
 import (
-	"errors"
+	"context"
+	"encoding/xml"
 	"fmt"
 	liberr "github.com/jortel/go-utils/error"
 	"github.com/konveyor/tackle2-addon/command"
+	pxy "github.com/konveyor/tackle2-addon/proxy"
 	"github.com/konveyor/tackle2-addon/ssh"
 	"github.com/konveyor/tackle2-hub/api"
 	"github.com/konveyor/tackle2-hub/nas"
@@ -12,13 +14,18 @@ import (
 	urllib "net/url"
 	"os"
 	pathlib "path"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // Subversion repository.
 type Subversion struct {
 	Remote
 	Path string
+	// relocated the working copy checked out
+	// from a mirror has been relocated to the URL.
+	relocated bool
 }
 
 // Validate settings.
@@ -27,24 +34,188 @@ func (r *Subversion) Validate() (err error) {
 	if err != nil {
 		return
 	}
-	insecure, err := addon.Setting.Bool("svn.insecure.enabled")
+	policy, err := r.findPolicy(r.Remote.URL, "svn.insecure.enabled")
 	if err != nil {
 		return
 	}
-	switch u.Scheme {
-	case "http":
-		if !insecure {
-			err = errors.New("http URL used with snv.insecure.enabled = FALSE")
-			return
-		}
+	err = policy.Validate(u.Scheme, command.Redact(r.Remote.URL))
+	return
+}
+
+// insecure returns true when TLS verification
+// is skipped for the (current) URL.
+func (r *Subversion) insecure() (insecure bool, err error) {
+	policy, err := r.findPolicy(r.Remote.URL, "svn.insecure.enabled")
+	if err != nil {
+		return
 	}
+	insecure = policy.Insecure
 	return
 }
 
 // Fetch clones the repository.
+// The mirrors are tried in order when the checkout fails.
+// When checked out from a mirror, the working copy is
+// relocated to the URL by Commit.
 func (r *Subversion) Fetch() (err error) {
+	primary := r.Remote.URL
+	for _, url := range r.urls() {
+		r.Remote.URL = url
+		err = r.fetch()
+		if err == nil {
+			if url != primary {
+				r.Mirror = url
+				addon.Activity("[SVN] Checked out using mirror: %s", command.Redact(url))
+			}
+			break
+		}
+	}
+	r.Remote.URL = primary
+	return
+}
+
+// relocate the working copy checked out from a mirror
+// to the URL. The relocation contacts the URL (UUID) so it
+// is deferred until changes are committed.
+func (r *Subversion) relocate() (err error) {
+	if r.Mirror == "" || r.relocated {
+		return
+	}
+	err = r.prepare()
+	if err != nil {
+		return
+	}
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("--non-interactive")
+	cmd.Options.Add("relocate", r.URL().String())
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	r.relocated = true
+	addon.Activity("[SVN] Relocated to: %s", command.Redact(r.URL().String()))
+	return
+}
+
+// fetch checks out the repository using the (current) URL.
+func (r *Subversion) fetch() (err error) {
+	url := r.URL()
+	addon.Activity("[SVN] Cloning: %s", command.Redact(url.String()))
+	err = r.prepare()
+	if err != nil {
+		return
+	}
+	return r.checkout(r.Remote.Branch)
+}
+
+// ListRefs lists the branches and tags without checkout.
+func (r *Subversion) ListRefs() (refs *Refs, err error) {
+	root, err := urllib.Parse(r.Remote.URL)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	addon.Activity("[SVN] Listing references: %s", command.Redact(root.String()))
+	err = r.prepare()
+	if err != nil {
+		return
+	}
+	entries, err := r.list(root)
+	if err != nil {
+		return
+	}
+	refs = &Refs{}
+	for _, entry := range entries {
+		switch entry {
+		case "trunk":
+			refs.Default = entry
+		case "branches":
+			u := *root
+			u.Path = pathlib.Join(u.Path, entry)
+			refs.Branches, err = r.list(&u)
+			if err != nil {
+				return
+			}
+		case "tags":
+			u := *root
+			u.Path = pathlib.Join(u.Path, entry)
+			refs.Tags, err = r.list(&u)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Verify validates the URL and settings and verifies the remote
+// is reachable, the credentials are accepted and the branch exists.
+func (r *Subversion) Verify() (result *Verification, err error) {
+	err = r.Validate()
+	if err != nil {
+		return
+	}
+	url := r.URL()
+	addon.Activity("[SVN] Verifying: %s", command.Redact(url.String()))
+	err = r.prepare()
+	if err != nil {
+		return
+	}
+	insecure, err := r.insecure()
+	if err != nil {
+		return
+	}
+	ctx, fn := context.WithTimeout(context.TODO(), time.Minute)
+	defer fn()
+	result = &Verification{}
+	cmd := r.newCommand()
+	cmd.Options.Add("--non-interactive")
+	if insecure {
+		cmd.Options.Add("--trust-server-cert")
+	}
+	cmd.Options.Add("info", url.String())
+	err = cmd.RunWith(ctx)
+	if err != nil {
+		err = nil
+		result.With(cmd.Output)
+		return
+	}
+	result.Reachable = true
+	result.Authenticated = true
+	result.RefExists = true
+	return
+}
+
+// list returns the directories in the remote directory.
+func (r *Subversion) list(url *urllib.URL) (dirs []string, err error) {
+	insecure, err := r.insecure()
+	if err != nil {
+		return
+	}
+	cmd := r.newCommand()
+	cmd.Options.Add("--non-interactive")
+	if insecure {
+		cmd.Options.Add("--trust-server-cert")
+	}
+	cmd.Options.Add("list", url.String())
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(cmd.Output), "\n") {
+		if strings.HasSuffix(line, "/") {
+			dirs = append(dirs, strings.TrimSuffix(line, "/"))
+		}
+	}
+	return
+}
+
+// prepare writes the configuration and credentials
+// and adds the ssh key used to access the remote.
+// The key is added to the agent only for svn+ssh URLs.
+func (r *Subversion) prepare() (err error) {
 	url := r.URL()
-	addon.Activity("[SVN] Cloning: %s", url.String())
 	id, found, err := r.findIdentity("source")
 	if err != nil {
 		return
@@ -65,23 +236,26 @@ func (r *Subversion) Fetch() (err error) {
 	if err != nil {
 		return
 	}
-	agent := ssh.Agent{}
-	err = agent.Add(id, url.Host)
+	agent, err := r.writeTunnel(id)
 	if err != nil {
 		return
 	}
-	return r.checkout(r.Remote.Branch)
+	if url.Scheme != "svn+ssh" {
+		return
+	}
+	err = agent.Add(id, url.Host)
+	return
 }
 
 // checkout Checkouts the repository.
 func (r *Subversion) checkout(branch string) (err error) {
 	url := r.URL()
 	_ = nas.RmDir(r.Path)
-	insecure, err := addon.Setting.Bool("svn.insecure.enabled")
+	insecure, err := r.insecure()
 	if err != nil {
 		return
 	}
-	cmd := command.Command{Path: "/usr/bin/svn"}
+	cmd := r.newCommand()
 	cmd.Options.Add("--non-interactive")
 	if insecure {
 		cmd.Options.Add("--trust-server-cert")
@@ -105,7 +279,7 @@ func (r *Subversion) Branch(name string) error {
 // createBranch creates a branch with the given name
 func (r *Subversion) createBranch(name string) (err error) {
 	url := *r.URL()
-	cmd := command.Command{Path: "/usr/bin/svn"}
+	cmd := r.newCommand()
 	cmd.Options.Add("--non-interactive")
 
 	branchUrl := url
@@ -121,7 +295,7 @@ func (r *Subversion) createBranch(name string) (err error) {
 
 // addFiles adds files to staging area
 func (r *Subversion) addFiles(files []string) (err error) {
-	cmd := command.Command{Path: "/usr/bin/svn"}
+	cmd := r.newCommand()
 	cmd.Dir = r.Path
 	cmd.Options.Add("add")
 	cmd.Options.Add("--force", files...)
@@ -129,19 +303,218 @@ func (r *Subversion) addFiles(files []string) (err error) {
 	return
 }
 
-// Commit records changes to the repo and push to the server
+// deleteFiles schedules missing files for deletion.
+func (r *Subversion) deleteFiles(files []string) (err error) {
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("delete")
+	cmd.Options.Add("--force", files...)
+	err = cmd.Run()
+	return
+}
+
+// Commit records changes to the repo and push to the server.
+// When no files are specified, all changed files are committed.
+// When checked out from a mirror, the working copy is relocated
+// to the URL first.
 func (r *Subversion) Commit(files []string, msg string) (err error) {
-	err = r.addFiles(files)
+	err = r.relocate()
 	if err != nil {
 		return
 	}
-	cmd := command.Command{Path: "/usr/bin/svn"}
+	if len(files) == 0 {
+		var status *Status
+		status, err = r.Status()
+		if err != nil {
+			return
+		}
+		if len(status.Deleted) > 0 {
+			err = r.deleteFiles(status.Deleted)
+			if err != nil {
+				return
+			}
+		}
+		files = status.Untracked
+	}
+	if len(files) > 0 {
+		err = r.addFiles(files)
+		if err != nil {
+			return
+		}
+	}
+	cmd := r.newCommand()
 	cmd.Dir = r.Path
 	cmd.Options.Add("commit", "-m", msg)
 	err = cmd.Run()
 	return
 }
 
+// Status returns the working copy status.
+func (r *Subversion) Status() (status *Status, err error) {
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("--non-interactive")
+	cmd.Options.Add("status")
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	status = &Status{}
+	for _, line := range strings.Split(string(cmd.Output), "\n") {
+		if len(line) < 9 {
+			continue
+		}
+		path := strings.TrimSpace(line[8:])
+		switch line[0] {
+		case '?':
+			status.Untracked = append(status.Untracked, path)
+		case 'A':
+			status.Added = append(status.Added, path)
+		case 'D', '!':
+			status.Deleted = append(status.Deleted, path)
+		case 'M', 'R', 'C':
+			status.Modified = append(status.Modified, path)
+		default:
+			if line[1] == 'M' {
+				status.Modified = append(status.Modified, path)
+			}
+		}
+	}
+	return
+}
+
+// Diff returns the (unified) diff of the working copy.
+func (r *Subversion) Diff() (diff string, err error) {
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("--non-interactive")
+	cmd.Options.Add("diff")
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	diff = string(cmd.Output)
+	return
+}
+
+// Log returns the commit history.
+func (r *Subversion) Log(filter LogFilter) (commits []Commit, err error) {
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("--non-interactive")
+	cmd.Options.Add("log", "--xml", "--verbose")
+	if !filter.Since.IsZero() || !filter.Until.IsZero() {
+		begin := "HEAD"
+		if !filter.Until.IsZero() {
+			begin = "{" + filter.Until.UTC().Format(time.RFC3339) + "}"
+		}
+		end := "1"
+		if !filter.Since.IsZero() {
+			end = "{" + filter.Since.UTC().Format(time.RFC3339) + "}"
+		}
+		cmd.Options.Addf("--revision=%s:%s", begin, end)
+	}
+	if filter.Max > 0 {
+		cmd.Options.Addf("--limit=%d", filter.Max)
+	}
+	if filter.Path != "" {
+		cmd.Options.Add(filter.Path)
+	}
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	log := struct {
+		Entries []struct {
+			Revision string   `xml:"revision,attr"`
+			Author   string   `xml:"author"`
+			Date     string   `xml:"date"`
+			Paths    []string `xml:"paths>path"`
+			Message  string   `xml:"msg"`
+		} `xml:"logentry"`
+	}{}
+	err = xml.Unmarshal(cmd.Output, &log)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	for _, entry := range log.Entries {
+		commit := Commit{
+			ID:      entry.Revision,
+			Author:  entry.Author,
+			Message: strings.TrimSpace(entry.Message),
+			Files:   entry.Paths,
+		}
+		commit.Date, err = time.Parse(time.RFC3339Nano, entry.Date)
+		if err != nil {
+			err = liberr.Wrap(err)
+			return
+		}
+		if filter.Match(commit.Date) {
+			commits = append(commits, commit)
+		}
+	}
+	return
+}
+
+// Apply applies a patch file (unified diff) to the working copy.
+// The patch is the path of the file (relative to the current
+// directory, not the working copy).
+// Hunks that could not be applied are returned.
+// The (*.svnpatch.rej) reject files are removed.
+func (r *Subversion) Apply(patch string) (rejected []Reject, err error) {
+	patch, err = patchPath(patch)
+	if err != nil {
+		return
+	}
+	addon.Activity("[SVN] Applying patch: %s", patch)
+	cmd := r.newCommand()
+	cmd.Dir = r.Path
+	cmd.Options.Add("--non-interactive")
+	cmd.Options.Add("patch", patch)
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	rejected = r.rejects(cmd.Output)
+	removeRejects(r.Path, rejected, ".svnpatch.rej")
+	return
+}
+
+// rejects parses `svn patch` output for rejected hunks.
+func (r *Subversion) rejects(output []byte) (rejected []Reject) {
+	status := regexp.MustCompile(`^([ADUCG])[ ADUCG]*\s+(.+)$`)
+	skipped := regexp.MustCompile(`^Skipped( missing target)?:? '(.+)'`)
+	hunk := regexp.MustCompile(`^>\s+rejected hunk (.+)$`)
+	path := ""
+	for _, line := range strings.Split(string(output), "\n") {
+		m := hunk.FindStringSubmatch(line)
+		if m != nil {
+			rejected = append(
+				rejected,
+				Reject{
+					Path: path,
+					Hunk: m[1],
+				})
+			continue
+		}
+		m = skipped.FindStringSubmatch(line)
+		if m != nil {
+			rejected = append(
+				rejected,
+				Reject{
+					Path: m[2],
+				})
+			continue
+		}
+		m = status.FindStringSubmatch(line)
+		if m != nil {
+			path = m[2]
+		}
+	}
+	return
+}
+
 // URL returns the parsed URL.
 func (r *Subversion) URL() (u *urllib.URL) {
 	u, _ = urllib.Parse(r.Remote.URL)
@@ -156,40 +529,23 @@ func (r *Subversion) URL() (u *urllib.URL) {
 }
 
 // writeConfig writes config file.
+// The content is built before the file is (re)written.
+// The file is scoped to the repository (--config-dir).
 func (r *Subversion) writeConfig() (err error) {
-	path := pathlib.Join(
-		HomeDir,
-		".subversion",
-		"servers")
-	found, err := nas.Exists(path)
-	if found || err != nil {
-		return
-	}
-	err = nas.MkDir(pathlib.Dir(path), 0755)
+	dir, err := r.configDir()
 	if err != nil {
 		return
 	}
-	f, err := os.Create(path)
-	if err != nil {
-		err = liberr.Wrap(
-			err,
-			"path",
-			path)
-		return
-	}
 	proxy, err := r.proxy()
 	if err != nil {
 		return
 	}
-	_, err = f.Write([]byte(proxy))
+	group, err := r.group()
 	if err != nil {
-		err = liberr.Wrap(
-			err,
-			"path",
-			path)
+		return
 	}
-	_ = f.Close()
-	addon.Activity("[FILE] Created %s.", path)
+	path := pathlib.Join(dir, "servers")
+	err = writeFile(path, proxy+group)
 	return
 }
 
@@ -199,20 +555,22 @@ func (r *Subversion) writePassword(id *api.Identity) (err error) {
 		return
 	}
 
-	cmd := command.Command{Path: "/usr/bin/svn"}
+	cmd := r.newCommand()
 	cmd.Options.Add("--non-interactive")
 	cmd.Options.Add("--username")
 	cmd.Options.Add(id.User)
-	cmd.Options.Add("--password")
-	cmd.Options.Add(id.Password)
+	cmd.Options.AddSecret("--password", id.Password)
 	cmd.Options.Add("info", r.URL().String())
 	err = cmd.RunSilent()
 	if err != nil {
 		return
 	}
-	dir := pathlib.Join(
-		HomeDir,
-		".subversion",
+	dir, err := r.configDir()
+	if err != nil {
+		return
+	}
+	dir = pathlib.Join(
+		dir,
 		"auth",
 		"svn.simple")
 
@@ -279,49 +637,171 @@ func (r *Subversion) writePassword(id *api.Identity) (err error) {
 }
 
 // proxy builds the proxy.
+// SOCKS proxies are not supported (http|https).
 func (r *Subversion) proxy() (proxy string, err error) {
-	kind := ""
+	p, err := pxy.Find(r.Remote.URL)
+	if err != nil || p == nil {
+		return
+	}
+	if p.SOCKS() {
+		err = liberr.New(
+			"SOCKS proxy not supported by svn (http|https).",
+			"proxy",
+			p.String())
+		return
+	}
+	addon.Activity("[SVN] Using proxy %s.", p)
+	proxy = "[global]\n"
+	proxy += fmt.Sprintf("http-proxy-host = %s\n", p.Host)
+	if p.Port > 0 {
+		proxy += fmt.Sprintf("http-proxy-port = %d\n", p.Port)
+	}
+	if p.User != "" {
+		proxy += fmt.Sprintf("http-proxy-username = %s\n", p.User)
+		proxy += fmt.Sprintf("http-proxy-password = %s\n", p.Password)
+	}
+	patterns := p.Patterns()
+	if len(patterns) > 0 {
+		proxy += fmt.Sprintf(
+			"http-proxy-exceptions = %s\n",
+			strings.Join(patterns, ", "))
+	}
+	return
+}
+
+// group builds the configuration scoped to the repository host (group).
+func (r *Subversion) group() (config string, err error) {
 	url := r.URL()
-	switch url.Scheme {
-	case "http":
-		kind = "http"
-	case "https",
-		"git@github.com":
-		kind = "https"
-	default:
+	if url.Scheme != "https" {
 		return
 	}
-	p, err := addon.Proxy.Find(kind)
-	if err != nil || p == nil || !p.Enabled {
+	cert, err := r.certificate()
+	if err != nil {
 		return
 	}
-	for _, h := range p.Excluded {
-		if h == url.Host {
-			return
-		}
+	authority, err := r.authority()
+	if err != nil {
+		return
+	}
+	if cert == "" && authority == "" {
+		return
+	}
+	config = "[groups]\n"
+	config += fmt.Sprintf("repository = %s\n", url.Hostname())
+	config += "[repository]\n"
+	config += cert
+	config += authority
+	return
+}
+
+// certificate builds the client certificate (mTLS) configuration.
+func (r *Subversion) certificate() (config string, err error) {
+	cert, found, err := r.findCertificate(r.Repository.URL)
+	if err != nil || !found {
+		return
+	}
+	dir, err := r.configDir()
+	if err != nil {
+		return
+	}
+	path, password, err := cert.WritePKCS12(dir)
+	if err != nil {
+		return
 	}
 	addon.Activity(
-		"[SVN] Using proxy (%d) %s.",
-		p.ID,
-		p.Kind)
-	var id *api.Identity
-	if p.Identity != nil {
-		id, err = addon.Identity.Get(p.Identity.ID)
+		"[SVN] Using client certificate (id=%d) %s for: %s",
+		cert.Identity.ID,
+		cert.Identity.Name,
+		r.URL().Host)
+	config += fmt.Sprintf("ssl-client-cert-file = %s\n", path)
+	config += fmt.Sprintf("ssl-client-cert-password = %s\n", password)
+	return
+}
+
+// authority builds the (trusted) CA certificates configuration.
+// Each certificate is written to a file because svn loads only
+// the first certificate in each file. The system CA certificates
+// are trusted by default (ssl-trust-default-ca).
+func (r *Subversion) authority() (config string, err error) {
+	bundle, found, err := r.findCA(r.Repository.URL)
+	if err != nil || !found {
+		return
+	}
+	dir, err := r.configDir()
+	if err != nil {
+		return
+	}
+	var paths []string
+	for i, certificate := range bundle.Split() {
+		path := pathlib.Join(dir, fmt.Sprintf("ca-%d.crt", i))
+		err = writeFile(path, certificate)
 		if err != nil {
 			return
 		}
+		paths = append(paths, path)
 	}
-	proxy = "[global]\n"
-	proxy += fmt.Sprintf("http-proxy-host = %s\n", p.Host)
-	if p.Port > 0 {
-		proxy += fmt.Sprintf("http-proxy-port = %d\n", p.Port)
+	addon.Activity(
+		"[SVN] Using CA certificates (%s) for: %s",
+		bundle.Source,
+		r.URL().Host)
+	config = fmt.Sprintf(
+		"ssl-authority-files = %s\n",
+		strings.Join(paths, ";"))
+	return
+}
+
+// writeTunnel returns the agent used to connect (svn+ssh).
+// The (svn+ssh) tunnel is configured to use the generated ssh_config.
+func (r *Subversion) writeTunnel(id *api.Identity) (agent *ssh.Agent, err error) {
+	url := r.URL()
+	if url.Scheme != "svn+ssh" {
+		agent = &ssh.Agent{}
+		return
 	}
-	if id != nil {
-		proxy += fmt.Sprintf("http-proxy-username = %s\n", id.User)
-		proxy += fmt.Sprintf("http-proxy-password = %s\n", id.Password)
+	agent, err = r.sshAgent(
+		r.Remote.URL,
+		ssh.Host{
+			Pattern: url.Hostname(),
+			Port:    url.Port(),
+			User:    url.User.Username(),
+		},
+		id)
+	if err != nil {
+		return
+	}
+	dir, err := r.configDir()
+	if err != nil {
+		return
+	}
+	path := pathlib.Join(dir, "config")
+	s := "[tunnels]\n"
+	s += fmt.Sprintf(
+		"ssh = $SVN_SSH %s -q\n",
+		agent.Config.Command())
+	err = writeFile(path, s)
+	return
+}
+
+// configDir returns the configuration directory
+// scoped to the repository. Created as needed.
+func (r *Subversion) configDir() (dir string, err error) {
+	home, err := r.home()
+	if err != nil {
+		return
+	}
+	dir = pathlib.Join(home, "subversion")
+	err = nas.MkDir(dir, 0700)
+	return
+}
+
+// newCommand returns an svn command using the
+// configuration scoped to the repository.
+func (r *Subversion) newCommand() (cmd command.Command) {
+	cmd = command.Command{Path: "/usr/bin/svn"}
+	if r.Home != "" {
+		cmd.Options.Add(
+			"--config-dir",
+			pathlib.Join(r.Home, "subversion"))
 	}
-	proxy += fmt.Sprintf(
-		"(http-proxy-exceptions = %s\n",
-		strings.Join(p.Excluded, " "))
 	return
 }
@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-addon/command"
+	"github.com/konveyor/tackle2-hub/api"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// GitHubAPI the default GitHub API URL.
+	GitHubAPI = "https://api.github.com"
+	// TokenUser the user sent with a token credential.
+	TokenUser = "x-access-token"
+	// TokenRenewal the (minimum) time remaining before an
+	// installation token expires for it to be (re)used.
+	TokenRenewal = 5 * time.Minute
+)
+
+// Installation tokens cached by App and key.
+var appTokens = &tokenCache{}
+
+// TokenSettings token authentication settings.
+// The token is the identity password. When a (GitHub) App is
+// specified, the identity key is the App private key (PEM) used to
+// obtain an installation token.
+// Example:
+//
+//	token:
+//	  header: true
+//	  app:
+//	    id: 1234
+//	    installation: 5678
+type TokenSettings struct {
+	// Header sends the token as an http Authorization (Bearer) header.
+	// Otherwise, the token is sent as the (x-access-token) credential.
+	Header bool `yaml:"header"`
+	// App GitHub App.
+	App *GitHubApp `yaml:"app"`
+}
+
+// token returns the token for the identity.
+// Installation tokens are cached until (about to) expire and
+// obtained using the TLS policy, CA certificates and the proxy
+// for the (App) API URL.
+func (r *Remote) token(settings *TokenSettings, id *api.Identity) (token string, err error) {
+	app := settings.App
+	if app == nil {
+		token = id.Password
+		return
+	}
+	key := appTokens.key(app, id.Key)
+	token, found := appTokens.Get(key)
+	if found {
+		return
+	}
+	transport, err := r.httpTransport(app.APIURL(), "git.insecure.enabled")
+	if err != nil {
+		return
+	}
+	token, expiration, err := app.Token(id.Key, transport)
+	if err != nil {
+		return
+	}
+	command.Secret(token)
+	appTokens.Put(key, token, expiration)
+	return
+}
+
+// tokenCache installation tokens.
+type tokenCache struct {
+	mutex   sync.Mutex
+	content map[string]cachedToken
+}
+
+// cachedToken a cached token.
+type cachedToken struct {
+	token      string
+	expiration time.Time
+}
+
+// Get returns the cached token.
+// Not found when expired or expires within TokenRenewal.
+func (r *tokenCache) Get(key string) (token string, found bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	cached, found := r.content[key]
+	if !found {
+		return
+	}
+	if time.Until(cached.expiration) < TokenRenewal {
+		delete(r.content, key)
+		found = false
+		return
+	}
+	token = cached.token
+	return
+}
+
+// Put caches the token.
+// Tokens without an expiration are not cached.
+func (r *tokenCache) Put(key, token string, expiration time.Time) {
+	if expiration.IsZero() {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.content == nil {
+		r.content = make(map[string]cachedToken)
+	}
+	r.content[key] = cachedToken{
+		token:      token,
+		expiration: expiration,
+	}
+}
+
+// key returns the cache key for the App and private key.
+func (r *tokenCache) key(app *GitHubApp, privateKey string) (key string) {
+	digest := sha256.Sum256([]byte(privateKey))
+	key = fmt.Sprintf(
+		"%s/%d/%d/%x",
+		app.APIURL(),
+		app.ID,
+		app.Installation,
+		digest)
+	return
+}
+
+// GitHubApp GitHub App used to obtain installation tokens.
+type GitHubApp struct {
+	// ID the App ID.
+	ID int64 `yaml:"id"`
+	// Installation the installation ID.
+	Installation int64 `yaml:"installation"`
+	// URL the API URL. Default: GitHubAPI.
+	URL string `yaml:"url"`
+}
+
+// APIURL returns the API URL.
+func (r *GitHubApp) APIURL() (url string) {
+	url = r.URL
+	if url == "" {
+		url = GitHubAPI
+	}
+	url = strings.TrimSuffix(url, "/")
+	return
+}
+
+// Token exchanges a (JWT) signed using the App
+// private key for an installation access token.
+// The transport is used to send the request.
+func (r *GitHubApp) Token(key string, transport http.RoundTripper) (token string, expiration time.Time, err error) {
+	jwt, err := r.jwt(key)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprintf(
+		"%s/app/installations/%d/access_tokens",
+		r.APIURL(),
+		r.Installation)
+	request, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	request.Header.Set("Authorization", "Bearer "+jwt)
+	request.Header.Set("Accept", "application/vnd.github+json")
+	client := http.Client{
+		Transport: transport,
+		Timeout:   time.Minute,
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusCreated {
+		err = liberr.New(
+			"GitHub App token exchange failed.",
+			"url",
+			url,
+			"status",
+			response.Status)
+		return
+	}
+	body := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	token = body.Token
+	expiration = body.ExpiresAt
+	return
+}
+
+// jwt returns a (RS256) JWT signed using the private key.
+func (r *GitHubApp) jwt(key string) (jwt string, err error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		err = liberr.New("GitHub App private key (PEM) not valid.")
+		return
+	}
+	var signer *rsa.PrivateKey
+	parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err == nil {
+		signer = parsed
+	} else {
+		pk, pErr := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pErr != nil {
+			err = liberr.Wrap(pErr)
+			return
+		}
+		rsaKey, cast := pk.(*rsa.PrivateKey)
+		if !cast {
+			err = liberr.New("GitHub App private key must be RSA.")
+			return
+		}
+		signer = rsaKey
+		err = nil
+	}
+	now := time.Now()
+	header, _ := json.Marshal(
+		map[string]string{
+			"alg": "RS256",
+			"typ": "JWT",
+		})
+	claims, _ := json.Marshal(
+		map[string]interface{}{
+			"iat": now.Add(-time.Minute).Unix(),
+			"exp": now.Add(9 * time.Minute).Unix(),
+			"iss": fmt.Sprintf("%d", r.ID),
+		})
+	encoding := base64.RawURLEncoding
+	var b bytes.Buffer
+	b.WriteString(encoding.EncodeToString(header))
+	b.WriteString(".")
+	b.WriteString(encoding.EncodeToString(claims))
+	digest := sha256.Sum256(b.Bytes())
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, digest[:])
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	b.WriteString(".")
+	b.WriteString(encoding.EncodeToString(signature))
+	jwt = b.String()
+	return
+}
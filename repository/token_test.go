@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitHubAppToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1 := string(pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}))
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8 := string(pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: der,
+		}))
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := string(pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: der,
+		}))
+
+	status := http.StatusCreated
+	var requestErr error
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestErr = verifyTokenRequest(r, &key.PublicKey)
+			if requestErr != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"token":"ghs_test","expires_at":"2030-01-02T03:04:05Z"}`))
+		}))
+	defer server.Close()
+
+	cases := []struct {
+		name    string
+		key     string
+		status  int
+		wantErr bool
+	}{
+		{name: "pkcs1", key: pkcs1, status: http.StatusCreated},
+		{name: "pkcs8", key: pkcs8, status: http.StatusCreated},
+		{name: "unauthorized", key: pkcs1, status: http.StatusUnauthorized, wantErr: true},
+		{name: "ok not created", key: pkcs1, status: http.StatusOK, wantErr: true},
+		{name: "server error", key: pkcs1, status: http.StatusInternalServerError, wantErr: true},
+		{name: "not pem", key: "not a key", status: http.StatusCreated, wantErr: true},
+		{name: "not rsa", key: ec, status: http.StatusCreated, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status = c.status
+			requestErr = nil
+			app := GitHubApp{
+				ID:           1234,
+				Installation: 5678,
+				URL:          server.URL + "/",
+			}
+			token, expiration, err := app.Token(c.key, http.DefaultTransport)
+			if requestErr != nil {
+				t.Fatalf("request not valid: %v", requestErr)
+			}
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got token: %q", token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token != "ghs_test" {
+				t.Fatalf("token: %q", token)
+			}
+			expected := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+			if !expiration.Equal(expected) {
+				t.Fatalf("expiration: %s, expected: %s", expiration, expected)
+			}
+		})
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	cache := &tokenCache{}
+	app := &GitHubApp{ID: 1234, Installation: 5678}
+	key := cache.key(app, "key")
+	if key == cache.key(app, "other") {
+		t.Fatal("key: same for different private keys.")
+	}
+	if key == cache.key(&GitHubApp{ID: 1234, Installation: 9}, "key") {
+		t.Fatal("key: same for different installations.")
+	}
+	if key != cache.key(&GitHubApp{ID: 1234, Installation: 5678, URL: GitHubAPI + "/"}, "key") {
+		t.Fatal("key: different for the same (default) API URL.")
+	}
+	if _, found := cache.Get(key); found {
+		t.Fatal("found: empty cache.")
+	}
+	cache.Put(key, "no-expiration", time.Time{})
+	if _, found := cache.Get(key); found {
+		t.Fatal("found: token without expiration cached.")
+	}
+	cache.Put(key, "valid", time.Now().Add(time.Hour))
+	token, found := cache.Get(key)
+	if !found || token != "valid" {
+		t.Fatalf("found: %t, token: %q", found, token)
+	}
+	cache.Put(key, "expiring", time.Now().Add(TokenRenewal/2))
+	if _, found = cache.Get(key); found {
+		t.Fatal("found: token expiring within the renewal period.")
+	}
+	cache.Put(key, "expired", time.Now().Add(-time.Minute))
+	if _, found = cache.Get(key); found {
+		t.Fatal("found: expired token.")
+	}
+}
+
+// verifyTokenRequest verifies the access_tokens request
+// and the (RS256) JWT signature and claims.
+func verifyTokenRequest(r *http.Request, key *rsa.PublicKey) (err error) {
+	if r.Method != http.MethodPost {
+		return fmt.Errorf("method: %s", r.Method)
+	}
+	if r.URL.Path != "/app/installations/5678/access_tokens" {
+		return fmt.Errorf("path: %s", r.URL.Path)
+	}
+	if r.Header.Get("Accept") != "application/vnd.github+json" {
+		return fmt.Errorf("accept: %s", r.Header.Get("Accept"))
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return fmt.Errorf("authorization: %s", auth)
+	}
+	part := strings.Split(strings.TrimPrefix(auth, "Bearer "), ".")
+	if len(part) != 3 {
+		return fmt.Errorf("jwt parts: %d", len(part))
+	}
+	encoding := base64.RawURLEncoding
+	signature, err := encoding.DecodeString(part[2])
+	if err != nil {
+		return
+	}
+	digest := sha256.Sum256([]byte(part[0] + "." + part[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return fmt.Errorf("signature: %v", err)
+	}
+	b, err := encoding.DecodeString(part[0])
+	if err != nil {
+		return
+	}
+	header := map[string]string{}
+	err = json.Unmarshal(b, &header)
+	if err != nil {
+		return
+	}
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		return fmt.Errorf("header: %v", header)
+	}
+	b, err = encoding.DecodeString(part[1])
+	if err != nil {
+		return
+	}
+	claims := struct {
+		IAT int64  `json:"iat"`
+		EXP int64  `json:"exp"`
+		ISS string `json:"iss"`
+	}{}
+	err = json.Unmarshal(b, &claims)
+	if err != nil {
+		return
+	}
+	now := time.Now().Unix()
+	if claims.ISS != "1234" {
+		return fmt.Errorf("iss: %s", claims.ISS)
+	}
+	if claims.IAT > now {
+		return fmt.Errorf("iat: %d in the future", claims.IAT)
+	}
+	if claims.EXP <= now {
+		return fmt.Errorf("exp: %d expired", claims.EXP)
+	}
+	if claims.EXP-claims.IAT > int64((10 * time.Minute).Seconds()) {
+		return fmt.Errorf("exp: %d more than 10 minutes after iat: %d", claims.EXP, claims.IAT)
+	}
+	return
+}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	liberr "github.com/jortel/go-utils/error"
+	"net"
+	urllib "net/url"
+	pathlib "path"
+	"strings"
+)
+
+// Default ports by scheme.
+var defaultPort = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ssh":   "22",
+	"git":   "9418",
+}
+
+// GitURL git clone URL.
+// Supported forms:
+//   - scheme://[user[:password]@]host[:port]/path
+//   - file:///path
+//   - [user@]host:path (scp-like)
+//   - /path (local)
+type GitURL struct {
+	Raw      string
+	Scheme   string
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Path     string
+	// scp-like form.
+	scp bool
+	// local path form.
+	local bool
+}
+
+// With populates the URL.
+func (r *GitURL) With(u string) (err error) {
+	*r = GitURL{Raw: u}
+	notValid := liberr.New(
+		"URL not valid.",
+		"url",
+		u)
+	switch {
+	case u == "":
+		err = notValid
+	case strings.Contains(u, "://"):
+		err = r.withURL(u)
+	case r.scpLike(u):
+		err = r.withScp(u)
+	case strings.HasPrefix(u, "/"):
+		r.Scheme = "file"
+		r.Path = u
+		r.local = true
+	default:
+		err = notValid
+	}
+	if err != nil {
+		err = notValid
+		return
+	}
+	switch r.Scheme {
+	case "file":
+	case "http",
+		"https",
+		"ssh",
+		"git":
+		if r.Host == "" {
+			err = notValid
+		}
+	default:
+		err = notValid
+	}
+	return
+}
+
+// Address returns host[:port].
+func (r *GitURL) Address() (a string) {
+	if r.Port != "" {
+		a = net.JoinHostPort(r.Host, r.Port)
+		return
+	}
+	a = r.Host
+	if strings.Contains(a, ":") {
+		a = "[" + a + "]"
+	}
+	return
+}
+
+// Normalize returns the URL in canonical form.
+// The scheme and host are lower case, scp-like URLs are
+// converted to ssh://, default ports are omitted and the
+// trailing slash and .git suffix are removed from the path.
+func (r *GitURL) Normalize() (n GitURL) {
+	n = *r
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if n.scp {
+		n.scp = false
+		n.Path = "/" + strings.TrimPrefix(n.Path, "/")
+	}
+	if n.Port == defaultPort[n.Scheme] {
+		n.Port = ""
+	}
+	if n.Path != "" {
+		n.Path = pathlib.Clean(n.Path)
+	}
+	n.Path = strings.TrimSuffix(n.Path, "/")
+	n.Path = strings.TrimSuffix(n.Path, ".git")
+	n.Raw = n.String()
+	return
+}
+
+// Equal returns true when both URLs reference the same
+// repository. The transport (scheme) and user are ignored.
+func (r *GitURL) Equal(other GitURL) (equal bool) {
+	a := r.Normalize()
+	b := other.Normalize()
+	if a.local || b.local {
+		a.Scheme, b.Scheme = "file", "file"
+	}
+	if (a.Scheme == "file") != (b.Scheme == "file") {
+		return
+	}
+	equal = a.Host == b.Host &&
+		a.Port == b.Port &&
+		a.Path == b.Path
+	return
+}
+
+// String representation.
+func (r *GitURL) String() (s string) {
+	switch {
+	case r.local:
+		s = r.Path
+	case r.scp:
+		if r.User != "" {
+			s = r.User + "@"
+		}
+		s += r.Address() + ":" + r.Path
+	default:
+		u := urllib.URL{
+			Scheme: r.Scheme,
+			Host:   r.Address(),
+			Path:   r.Path,
+		}
+		if r.User != "" {
+			if r.Password != "" {
+				u.User = urllib.UserPassword(r.User, r.Password)
+			} else {
+				u.User = urllib.User(r.User)
+			}
+		}
+		s = u.String()
+	}
+	return
+}
+
+// withURL populates the URL using the standard URL form.
+func (r *GitURL) withURL(u string) (err error) {
+	parsed, err := urllib.Parse(u)
+	if err != nil {
+		return
+	}
+	r.Scheme = strings.ToLower(parsed.Scheme)
+	switch r.Scheme {
+	case "git+ssh",
+		"ssh+git":
+		r.Scheme = "ssh"
+	}
+	if parsed.User != nil {
+		r.User = parsed.User.Username()
+		r.Password, _ = parsed.User.Password()
+	}
+	r.Host = parsed.Hostname()
+	r.Port = parsed.Port()
+	r.Path = parsed.Path
+	return
+}
+
+// withScp populates the URL using the scp-like form.
+func (r *GitURL) withScp(u string) (err error) {
+	r.Scheme = "ssh"
+	r.scp = true
+	var host string
+	if strings.HasPrefix(u, "[") || strings.Contains(u, "@[") {
+		end := strings.Index(u, "]:")
+		host = u[:end+1]
+		r.Path = u[end+2:]
+	} else {
+		colon := strings.Index(u, ":")
+		host = u[:colon]
+		r.Path = u[colon+1:]
+	}
+	at := strings.LastIndex(host, "@")
+	if at != -1 {
+		r.User = host[:at]
+		host = host[at+1:]
+	}
+	r.Host = strings.Trim(host, "[]")
+	if r.Host == "" || r.Path == "" {
+		err = liberr.New("URL not valid.")
+	}
+	return
+}
+
+// scpLike returns true when the URL uses the scp-like form.
+// As with git, a colon must precede the first slash.
+func (r *GitURL) scpLike(u string) (matched bool) {
+	if strings.HasPrefix(u, "[") || strings.Contains(u, "@[") {
+		matched = strings.Contains(u, "]:")
+		return
+	}
+	colon := strings.Index(u, ":")
+	if colon < 1 {
+		return
+	}
+	slash := strings.Index(u, "/")
+	matched = slash == -1 || colon < slash
+	return
+}
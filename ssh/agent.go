@@ -0,0 +1,152 @@
+package ssh
+
+import (
+	"errors"
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-addon/teardown"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+	pathlib "path"
+	"sync"
+)
+
+var (
+	server = AgentServer{}
+)
+
+//
+// AgentServer an in-process ssh agent serving the keys held in
+// memory using the ssh-agent protocol on a private (unix) socket.
+type AgentServer struct {
+	// Path of the socket.
+	Path     string
+	keyring  agent.Agent
+	listener net.Listener
+	added    map[uint]bool
+	mutex    sync.Mutex
+}
+
+//
+// Start listening on a socket in a private directory.
+// The agent is registered to be stopped on teardown.
+func (r *AgentServer) Start() (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.listener != nil {
+		return
+	}
+	dir, err := os.MkdirTemp("", "agent.")
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	teardown.File(dir)
+	r.Path = pathlib.Join(dir, "agent.sock")
+	r.listener, err = net.Listen("unix", r.Path)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			r.Path)
+		return
+	}
+	err = os.Chmod(r.Path, 0600)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			r.Path)
+		return
+	}
+	r.keyring = agent.NewKeyring()
+	r.added = make(map[uint]bool)
+	teardown.Func(
+		"ssh-agent",
+		func() (err error) {
+			r.Stop()
+			return
+		})
+	go r.serve(r.listener, r.keyring)
+	return
+}
+
+//
+// Running returns true when started.
+func (r *AgentServer) Running() (running bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	running = r.listener != nil
+	return
+}
+
+//
+// Stop listening and forget the keys.
+func (r *AgentServer) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.listener == nil {
+		return
+	}
+	_ = r.listener.Close()
+	_ = r.keyring.RemoveAll()
+	r.listener = nil
+	r.added = nil
+}
+
+//
+// Add the key for the identity.
+// Returns false when already added.
+func (r *AgentServer) Add(key *Key) (added bool, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.listener == nil {
+		err = liberr.New("ssh agent not started.")
+		return
+	}
+	if r.added[key.Identity.ID] {
+		return
+	}
+	err = r.keyring.Add(
+		agent.AddedKey{
+			PrivateKey: key.Private,
+			Comment:    key.Identity.Name,
+		})
+	if err != nil {
+		err = liberr.Wrap(err)
+		return
+	}
+	if key.Certificate != nil {
+		err = r.keyring.Add(
+			agent.AddedKey{
+				PrivateKey:  key.Private,
+				Certificate: key.Certificate,
+				Comment:     key.Identity.Name,
+			})
+		if err != nil {
+			err = liberr.Wrap(err)
+			return
+		}
+	}
+	r.added[key.Identity.ID] = true
+	added = true
+	return
+}
+
+//
+// serve accepts connections.
+func (r *AgentServer) serve(listener net.Listener, keyring agent.Agent) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go func() {
+			_ = agent.ServeAgent(keyring, conn)
+			_ = conn.Close()
+		}()
+	}
+}
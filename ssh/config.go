@@ -0,0 +1,156 @@
+package ssh
+
+import (
+	"fmt"
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-addon/teardown"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//
+// Config a generated ssh client configuration (ssh_config).
+// Used with: ssh -F <path>.
+type Config struct {
+	// Path of the file.
+	Path string
+	// Hosts the host blocks.
+	Hosts []Host
+}
+
+//
+// Add a host block.
+// Replaces a block with the same pattern.
+func (r *Config) Add(host Host) {
+	for i := range r.Hosts {
+		if r.Hosts[i].Pattern == host.Pattern {
+			r.Hosts[i] = host
+			return
+		}
+	}
+	r.Hosts = append(r.Hosts, host)
+}
+
+//
+// Find the host block by pattern.
+// Returns nil when not found.
+func (r *Config) Find(pattern string) (host *Host) {
+	for i := range r.Hosts {
+		if r.Hosts[i].Pattern == pattern {
+			host = &r.Hosts[i]
+			break
+		}
+	}
+	return
+}
+
+//
+// Command returns the ssh command using the configuration.
+func (r *Config) Command() (command string) {
+	command = "ssh -F " + r.Path
+	return
+}
+
+//
+// Write the file.
+func (r *Config) Write() (err error) {
+	f, err := os.OpenFile(
+		r.Path,
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC,
+		0600)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			r.Path)
+		return
+	}
+	teardown.File(r.Path)
+	s := ""
+	for i := range r.Hosts {
+		s += r.Hosts[i].String()
+	}
+	_, err = f.Write([]byte(s))
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			r.Path)
+	}
+	_ = f.Close()
+	addon.Activity("[FILE] Created %s.", r.Path)
+	return
+}
+
+//
+// Host an ssh_config host block.
+type Host struct {
+	// Pattern matched by the block.
+	Pattern string
+	// HostName the (real) host name.
+	HostName string
+	// Port the port.
+	Port string
+	// User the user.
+	User string
+	// IdentityFile the key file.
+	IdentityFile string
+	// CertificateFile the (OpenSSH) certificate file.
+	CertificateFile string
+	// ProxyCommand the command used to connect.
+	ProxyCommand string
+	// ProxyJump the (bastion) jump host.
+	ProxyJump string
+	// IdentitiesOnly offer only the IdentityFile.
+	IdentitiesOnly bool
+	// StrictHostKeyChecking (yes|accept-new|no).
+	StrictHostKeyChecking string
+	// UserKnownHostsFile the known hosts file.
+	UserKnownHostsFile string
+	// ConnectTimeout (seconds).
+	ConnectTimeout int
+}
+
+//
+// Proxied returns true when connected using a proxy or bastion.
+func (r *Host) Proxied() (proxied bool) {
+	proxied = r.ProxyCommand != "" || r.ProxyJump != ""
+	return
+}
+
+//
+// String returns the block.
+func (r *Host) String() (s string) {
+	s = fmt.Sprintf("Host %s\n", r.Pattern)
+	identitiesOnly := ""
+	if r.IdentitiesOnly {
+		identitiesOnly = "yes"
+	}
+	connectTimeout := ""
+	if r.ConnectTimeout > 0 {
+		connectTimeout = strconv.Itoa(r.ConnectTimeout)
+	}
+	for _, option := range [][2]string{
+		{"HostName", r.HostName},
+		{"Port", r.Port},
+		{"User", r.User},
+		{"IdentityFile", r.IdentityFile},
+		{"CertificateFile", r.CertificateFile},
+		{"IdentitiesOnly", identitiesOnly},
+		{"ProxyCommand", r.ProxyCommand},
+		{"ProxyJump", r.ProxyJump},
+		{"StrictHostKeyChecking", r.StrictHostKeyChecking},
+		{"UserKnownHostsFile", r.UserKnownHostsFile},
+		{"ConnectTimeout", connectTimeout},
+	} {
+		if option[1] == "" {
+			continue
+		}
+		s += fmt.Sprintf(
+			"  %s %s\n",
+			option[0],
+			strings.TrimSpace(option[1]))
+	}
+	return
+}
@@ -0,0 +1,359 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	liberr "github.com/jortel/go-utils/error"
+	"github.com/konveyor/tackle2-hub/api"
+	xssh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
+	"strconv"
+	"strings"
+)
+
+// Key formats.
+const (
+	OpenSSH        = "openssh"
+	PKCS1          = "pkcs1"
+	PKCS8          = "pkcs8"
+	PKCS8Encrypted = "pkcs8-encrypted"
+	SEC1           = "sec1"
+	DSA            = "dsa"
+	PuTTY          = "putty"
+	SSH2           = "ssh2"
+	PublicOnly     = "public"
+)
+
+const (
+	// MinRSABits the minimum RSA key size accepted by ssh.
+	MinRSABits = 1024
+)
+
+//
+// KeyError reports a key that is not valid.
+type KeyError struct {
+	// Identity the identity name.
+	Identity string
+	// Reason the key is not valid.
+	Reason string
+}
+
+//
+// Error returns the description.
+func (e *KeyError) Error() (s string) {
+	s = "SSH key (identity: " + e.Identity + ") not valid: " + e.Reason
+	return
+}
+
+//
+// Is matches the error type.
+func (e *KeyError) Is(err error) (matched bool) {
+	_, matched = err.(*KeyError)
+	return
+}
+
+//
+// PassphraseError reports an encrypted key with
+// a missing or incorrect passphrase (password).
+type PassphraseError struct {
+	// Identity the identity name.
+	Identity string
+	// Missing true when the passphrase is missing.
+	Missing bool
+}
+
+//
+// Error returns the description.
+func (e *PassphraseError) Error() (s string) {
+	s = "SSH key (identity: " + e.Identity + ") is encrypted: "
+	if e.Missing {
+		s += "passphrase (password) required."
+	} else {
+		s += "passphrase (password) not correct."
+	}
+	return
+}
+
+//
+// Is matches the error type.
+func (e *PassphraseError) Is(err error) (matched bool) {
+	_, matched = err.(*PassphraseError)
+	return
+}
+
+//
+// FormatError reports a key format that is not supported.
+type FormatError struct {
+	// Identity the identity name.
+	Identity string
+	// Format the key format.
+	Format string
+	// Reason (optional) the format is not supported.
+	Reason string
+}
+
+//
+// Error returns the description.
+func (e *FormatError) Error() (s string) {
+	format := e.Format
+	if format == "" {
+		format = "unknown"
+	}
+	s = "SSH key (identity: " + e.Identity + ") format (" + format + ") not supported."
+	if e.Reason != "" {
+		s += " " + e.Reason
+	}
+	return
+}
+
+//
+// Is matches the error type.
+func (e *FormatError) Is(err error) (matched bool) {
+	_, matched = err.(*FormatError)
+	return
+}
+
+//
+// KeyFormat returns the format of the key.
+// Empty when not recognized.
+func KeyFormat(content []byte) (format string) {
+	content = bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(content, []byte(puttyHeader)):
+		format = PuTTY
+		return
+	case bytes.HasPrefix(content, []byte("---- BEGIN SSH2")):
+		format = SSH2
+		return
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		_, _, _, _, err := xssh.ParseAuthorizedKey(content)
+		if err == nil {
+			format = PublicOnly
+		}
+		return
+	}
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		format = OpenSSH
+	case "RSA PRIVATE KEY":
+		format = PKCS1
+	case "PRIVATE KEY":
+		format = PKCS8
+	case "ENCRYPTED PRIVATE KEY":
+		format = PKCS8Encrypted
+	case "EC PRIVATE KEY":
+		format = SEC1
+	case "DSA PRIVATE KEY":
+		format = DSA
+	default:
+		format = strings.ToLower(block.Type)
+	}
+	return
+}
+
+//
+// Key a (decrypted) private key held in memory.
+type Key struct {
+	// Identity the identity.
+	Identity *api.Identity
+	// Format the (original) key format.
+	Format string
+	// Private the private key.
+	Private interface{}
+	// Public the public key.
+	Public xssh.PublicKey
+	// Certificate the (optional) OpenSSH certificate.
+	Certificate *xssh.Certificate
+}
+
+//
+// With parses and validates the key (and certificate) in the identity.
+// Encrypted keys are decrypted using the identity password.
+// PuTTY and (encrypted) PKCS#8 keys are converted.
+func (r *Key) With(id *api.Identity) (err error) {
+	r.Identity = id
+	content := []byte(strings.TrimSpace(id.Key) + "\n")
+	r.Format = KeyFormat(content)
+	switch r.Format {
+	case PuTTY:
+		r.Private, err = r.putty(content)
+	case PKCS8Encrypted:
+		r.Private, err = r.pkcs8(content)
+	case OpenSSH, PKCS1, PKCS8, SEC1:
+		r.Private, err = r.pem(content)
+	case PublicOnly:
+		err = &KeyError{
+			Identity: id.Name,
+			Reason:   "public key found (private key required).",
+		}
+	case DSA:
+		err = &FormatError{
+			Identity: id.Name,
+			Format:   r.Format,
+			Reason:   "DSA keys are not supported by ssh.",
+		}
+	case SSH2:
+		err = &FormatError{
+			Identity: id.Name,
+			Format:   r.Format,
+			Reason:   "Convert using: ssh-keygen -i -f <file>.",
+		}
+	default:
+		err = &FormatError{
+			Identity: id.Name,
+			Format:   r.Format,
+		}
+	}
+	if err != nil {
+		return
+	}
+	err = r.validate()
+	if err != nil {
+		return
+	}
+	err = r.withCertificate()
+	return
+}
+
+//
+// Type returns the key type.
+func (r *Key) Type() (kind string) {
+	kind = r.Public.Type()
+	return
+}
+
+//
+// Fingerprint returns the (SHA256) fingerprint of the public key.
+func (r *Key) Fingerprint() (fingerprint string) {
+	fingerprint = Fingerprint(r.Public)
+	return
+}
+
+//
+// Converted returns true when converted from
+// a format not supported by ssh (and ssh-add).
+func (r *Key) Converted() (converted bool) {
+	converted = r.Format == PuTTY || r.Format == PKCS8Encrypted
+	return
+}
+
+//
+// pem parses a (PEM) key supported by ssh.
+func (r *Key) pem(content []byte) (key interface{}, err error) {
+	key, err = xssh.ParseRawPrivateKey(content)
+	var missing *xssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if r.Identity.Password == "" {
+			err = &PassphraseError{
+				Identity: r.Identity.Name,
+				Missing:  true,
+			}
+			return
+		}
+		key, err = xssh.ParseRawPrivateKeyWithPassphrase(
+			content,
+			[]byte(r.Identity.Password))
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			err = &PassphraseError{
+				Identity: r.Identity.Name,
+			}
+			return
+		}
+	}
+	if err != nil {
+		err = &KeyError{
+			Identity: r.Identity.Name,
+			Reason:   err.Error(),
+		}
+	}
+	return
+}
+
+//
+// validate the private key and derive the public key.
+func (r *Key) validate() (err error) {
+	switch key := r.Private.(type) {
+	case *ed25519.PrivateKey:
+		r.Private = *key
+	case *rsa.PrivateKey:
+		err = key.Validate()
+		if err != nil {
+			err = &KeyError{
+				Identity: r.Identity.Name,
+				Reason:   err.Error(),
+			}
+			return
+		}
+		bits := key.N.BitLen()
+		if bits < MinRSABits {
+			err = &KeyError{
+				Identity: r.Identity.Name,
+				Reason: "RSA key size: " + strconv.Itoa(bits) +
+					" bits (minimum: " + strconv.Itoa(MinRSABits) + ").",
+			}
+			return
+		}
+	case *dsa.PrivateKey:
+		err = &FormatError{
+			Identity: r.Identity.Name,
+			Format:   DSA,
+			Reason:   "DSA keys are not supported by ssh.",
+		}
+		return
+	}
+	signer, err := xssh.NewSignerFromKey(r.Private)
+	if err != nil {
+		err = &KeyError{
+			Identity: r.Identity.Name,
+			Reason:   err.Error(),
+		}
+		return
+	}
+	r.Public = signer.PublicKey()
+	return
+}
+
+//
+// withCertificate parses the (OpenSSH) certificate
+// declared in the identity settings (sshCertificate).
+func (r *Key) withCertificate() (err error) {
+	settings := struct {
+		Certificate string `yaml:"sshCertificate"`
+	}{}
+	_ = yaml.Unmarshal([]byte(r.Identity.Settings), &settings)
+	if settings.Certificate == "" {
+		return
+	}
+	parsed, _, _, _, err := xssh.ParseAuthorizedKey([]byte(settings.Certificate))
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"identity",
+			r.Identity.Name)
+		return
+	}
+	cert, cast := parsed.(*xssh.Certificate)
+	if !cast {
+		err = liberr.New(
+			"ssh certificate not valid.",
+			"identity",
+			r.Identity.Name)
+		return
+	}
+	if string(cert.Key.Marshal()) != string(r.Public.Marshal()) {
+		err = liberr.New(
+			"ssh certificate does not match the key.",
+			"identity",
+			r.Identity.Name)
+		return
+	}
+	r.Certificate = cert
+	return
+}
@@ -0,0 +1,295 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	liberr "github.com/jortel/go-utils/error"
+	hub "github.com/konveyor/tackle2-hub/addon"
+	xssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	// KnownHostsSetting the pinned host keys setting key.
+	// The value is a map of host (or host:port) to the list of
+	// pinned keys (authorized_keys format) or fingerprints.
+	// Example:
+	//
+	//	{
+	//	  "github.com": ["SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU"],
+	//	  "git.example.com:2222": ["ssh-ed25519 AAAAC3Nza..."]
+	//	}
+	KnownHostsSetting = "ssh.known.hosts"
+	// KeyScanSetting permits host keys to be scanned
+	// and trusted (on first use) when not pinned.
+	KeyScanSetting = "ssh.keyscan.enabled"
+)
+
+//
+// HostKeyError reports a host key that cannot be trusted.
+type HostKeyError struct {
+	// Host the host (host[:port]).
+	Host string
+	// Reason the key is not trusted.
+	Reason string
+	// Scanned the fingerprints of the scanned keys.
+	Scanned []string
+}
+
+//
+// Error returns the description.
+func (e *HostKeyError) Error() (s string) {
+	s = "Host key for " + e.Host + " not trusted: " + e.Reason
+	if len(e.Scanned) > 0 {
+		s += " Scanned: " + strings.Join(e.Scanned, ", ")
+	}
+	return
+}
+
+//
+// Is matches the error type.
+func (e *HostKeyError) Is(err error) (matched bool) {
+	_, matched = err.(*HostKeyError)
+	return
+}
+
+//
+// Pins pinned host keys and fingerprints.
+type Pins map[string][]string
+
+//
+// Add pins for the host (host[:port]).
+func (r Pins) Add(host string, pins ...string) {
+	address := Normalize(host)
+	for _, pin := range pins {
+		pin = strings.TrimSpace(pin)
+		if pin != "" {
+			r[address] = append(r[address], pin)
+		}
+	}
+}
+
+//
+// With adds the pins defined in the settings.
+func (r Pins) With(settings map[string][]string) {
+	for host, pins := range settings {
+		r.Add(host, pins...)
+	}
+}
+
+//
+// Find returns the pins for the host (host[:port]).
+func (r Pins) Find(host string) (pins []string) {
+	pins = r[Normalize(host)]
+	return
+}
+
+//
+// Normalize returns the known hosts address: host or [host]:port.
+// The port is omitted when 22.
+func Normalize(host string) (address string) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	address = knownhosts.Normalize(host)
+	return
+}
+
+//
+// Fingerprint returns the (SHA256) fingerprint of the key.
+func Fingerprint(key xssh.PublicKey) (fingerprint string) {
+	fingerprint = xssh.FingerprintSHA256(key)
+	return
+}
+
+//
+// KnownHost a trusted host key.
+type KnownHost struct {
+	// Address host or [host]:port.
+	Address string
+	// Key the public key.
+	Key xssh.PublicKey
+}
+
+//
+// Line returns the known_hosts line.
+func (r *KnownHost) Line() (line string) {
+	line = r.Address + " " + string(bytes.TrimSpace(xssh.MarshalAuthorizedKey(r.Key)))
+	return
+}
+
+//
+// Verify the scanned keys (known_hosts format) using the pins.
+// A pin matches a key by (SHA256|MD5) fingerprint or public key.
+// Returns the matched keys.
+func Verify(host string, pins []string, scanned []byte) (matched []KnownHost, err error) {
+	var fingerprints []string
+	for _, key := range Parse(host, scanned) {
+		fingerprints = append(
+			fingerprints,
+			key.Key.Type()+" "+Fingerprint(key.Key))
+		for _, pin := range pins {
+			if pinned(pin, key.Key) {
+				matched = append(matched, key)
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		err = &HostKeyError{
+			Host:    Normalize(host),
+			Reason:  "scanned keys do not match the pinned keys.",
+			Scanned: fingerprints,
+		}
+	}
+	return
+}
+
+//
+// Parse the scanned keys (known_hosts format).
+func Parse(host string, scanned []byte) (keys []KnownHost) {
+	address := Normalize(host)
+	scanner := bufio.NewScanner(bytes.NewReader(scanned))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		_, _, key, _, _, pErr := xssh.ParseKnownHosts(line)
+		if pErr != nil {
+			continue
+		}
+		keys = append(
+			keys,
+			KnownHost{
+				Address: address,
+				Key:     key,
+			})
+	}
+	return
+}
+
+//
+// PinnedKeys returns the pins which are public keys.
+func PinnedKeys(host string, pins []string) (keys []KnownHost) {
+	address := Normalize(host)
+	for _, pin := range pins {
+		key, _, _, _, err := xssh.ParseAuthorizedKey([]byte(pin))
+		if err != nil {
+			continue
+		}
+		keys = append(
+			keys,
+			KnownHost{
+				Address: address,
+				Key:     key,
+			})
+	}
+	return
+}
+
+//
+// pinned returns true when the pin matches the key.
+func pinned(pin string, key xssh.PublicKey) (matched bool) {
+	switch {
+	case strings.HasPrefix(pin, "SHA256:"):
+		matched = pin == xssh.FingerprintSHA256(key)
+	case strings.HasPrefix(pin, "MD5:"):
+		matched = strings.EqualFold(
+			strings.TrimPrefix(pin, "MD5:"),
+			xssh.FingerprintLegacyMD5(key))
+	default:
+		pinnedKey, _, _, _, err := xssh.ParseAuthorizedKey([]byte(pin))
+		if err == nil {
+			matched = bytes.Equal(pinnedKey.Marshal(), key.Marshal())
+		}
+	}
+	return
+}
+
+//
+// Known returns true when a key for the host is in the known hosts.
+func Known(host string) (known bool) {
+	b, err := os.ReadFile(KnownHosts)
+	if err != nil {
+		return
+	}
+	address := Normalize(host)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		_, hosts, _, _, _, pErr := xssh.ParseKnownHosts(scanner.Bytes())
+		if pErr != nil {
+			continue
+		}
+		for _, h := range hosts {
+			if h == address {
+				known = true
+				return
+			}
+		}
+	}
+	return
+}
+
+//
+// Trust adds the keys to the known hosts.
+// Keys already known are not added.
+func Trust(keys []KnownHost, how string) (err error) {
+	content, _ := os.ReadFile(KnownHosts)
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		lines[strings.TrimSpace(line)] = true
+	}
+	f, err := os.OpenFile(
+		KnownHosts,
+		os.O_RDWR|os.O_APPEND|os.O_CREATE,
+		0644)
+	if err != nil {
+		err = liberr.Wrap(
+			err,
+			"path",
+			KnownHosts)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	for i := range keys {
+		key := &keys[i]
+		addon.Activity(
+			"[SSH] Host key trusted (%s): %s %s %s",
+			how,
+			key.Address,
+			key.Key.Type(),
+			Fingerprint(key.Key))
+		line := key.Line()
+		if lines[line] {
+			continue
+		}
+		lines[line] = true
+		_, err = f.Write([]byte(line + "\n"))
+		if err != nil {
+			err = liberr.Wrap(
+				err,
+				"path",
+				KnownHosts)
+			return
+		}
+	}
+	return
+}
+
+//
+// setting gets an optional setting.
+// Not found is not an error.
+func setting(key string, v interface{}) (err error) {
+	err = addon.Setting.Get(key, v)
+	if errors.Is(err, &hub.NotFound{}) {
+		err = nil
+	}
+	return
+}
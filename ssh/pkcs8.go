@@ -0,0 +1,187 @@
+package ssh
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+)
+
+// PKCS#5 (v2) object identifiers.
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+//
+// EncryptedPKCS8 an encrypted PKCS#8 private key.
+// Only PBES2 using PBKDF2 and AES-CBC is supported.
+type EncryptedPKCS8 struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Encrypted []byte
+}
+
+//
+// pbes2 PBES2 parameters.
+type pbes2 struct {
+	KDF    pkix.AlgorithmIdentifier
+	Cipher pkix.AlgorithmIdentifier
+}
+
+//
+// pbkdf2Params PBKDF2 parameters.
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+//
+// Decrypt the key using the passphrase.
+// Returns the (unencrypted) PKCS#8 DER.
+func (r *EncryptedPKCS8) Decrypt(passphrase string) (der []byte, err error) {
+	if !r.Algorithm.Algorithm.Equal(oidPBES2) {
+		err = errors.New("encryption: " + r.Algorithm.Algorithm.String() + " not supported (PBES2 required).")
+		return
+	}
+	params := pbes2{}
+	_, err = asn1.Unmarshal(r.Algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return
+	}
+	if !params.KDF.Algorithm.Equal(oidPBKDF2) {
+		err = errors.New("key derivation: " + params.KDF.Algorithm.String() + " not supported (PBKDF2 required).")
+		return
+	}
+	kdf := pbkdf2Params{}
+	_, err = asn1.Unmarshal(params.KDF.Parameters.FullBytes, &kdf)
+	if err != nil {
+		return
+	}
+	var newHash func() hash.Hash
+	switch prf := kdf.PRF.Algorithm; {
+	case len(prf) == 0, prf.Equal(oidHMACWithSHA1):
+		newHash = sha1.New
+	case prf.Equal(oidHMACWithSHA256):
+		newHash = sha256.New
+	case prf.Equal(oidHMACWithSHA512):
+		newHash = sha512.New
+	default:
+		err = errors.New("PRF: " + prf.String() + " not supported.")
+		return
+	}
+	var keyLength int
+	switch c := params.Cipher.Algorithm; {
+	case c.Equal(oidAES128CBC):
+		keyLength = 16
+	case c.Equal(oidAES192CBC):
+		keyLength = 24
+	case c.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		err = errors.New("cipher: " + c.String() + " not supported (AES-CBC required).")
+		return
+	}
+	var iv []byte
+	_, err = asn1.Unmarshal(params.Cipher.Parameters.FullBytes, &iv)
+	if err != nil {
+		return
+	}
+	if len(iv) != aes.BlockSize || len(r.Encrypted)%aes.BlockSize != 0 {
+		err = errors.New("encrypted key not valid.")
+		return
+	}
+	key := pbkdf2.Key(
+		[]byte(passphrase),
+		kdf.Salt,
+		kdf.Iterations,
+		keyLength,
+		newHash)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	der = make([]byte, len(r.Encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(der, r.Encrypted)
+	der, err = unpad(der)
+	return
+}
+
+//
+// unpad removes the (PKCS#7) padding.
+func unpad(b []byte) (unpadded []byte, err error) {
+	n := 0
+	if len(b) > 0 {
+		n = int(b[len(b)-1])
+	}
+	if n == 0 || n > aes.BlockSize || n > len(b) {
+		err = x509.IncorrectPasswordError
+		return
+	}
+	for _, p := range b[len(b)-n:] {
+		if int(p) != n {
+			err = x509.IncorrectPasswordError
+			return
+		}
+	}
+	unpadded = b[:len(b)-n]
+	return
+}
+
+//
+// pkcs8 parses (and decrypts) an encrypted PKCS#8 key.
+func (r *Key) pkcs8(content []byte) (key interface{}, err error) {
+	if r.Identity.Password == "" {
+		err = &PassphraseError{
+			Identity: r.Identity.Name,
+			Missing:  true,
+		}
+		return
+	}
+	block, _ := pem.Decode(content)
+	encrypted := EncryptedPKCS8{}
+	_, err = asn1.Unmarshal(block.Bytes, &encrypted)
+	if err != nil {
+		err = &KeyError{
+			Identity: r.Identity.Name,
+			Reason:   err.Error(),
+		}
+		return
+	}
+	der, err := encrypted.Decrypt(r.Identity.Password)
+	if err == nil {
+		key, err = x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			err = x509.IncorrectPasswordError
+		}
+	}
+	if err != nil {
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			err = &PassphraseError{
+				Identity: r.Identity.Name,
+			}
+		} else {
+			err = &FormatError{
+				Identity: r.Identity.Name,
+				Format:   PKCS8Encrypted,
+				Reason:   err.Error(),
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,422 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	xssh "golang.org/x/crypto/ssh"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// puttyHeader the PuTTY (.ppk) key file header.
+	puttyHeader = "PuTTY-User-Key-File-"
+)
+
+// Argon2 (version 3) parameter limits.
+const (
+	// MaxArgon2Memory the maximum memory (KiB).
+	MaxArgon2Memory = 1024 * 1024
+	// MaxArgon2Passes the maximum passes.
+	MaxArgon2Passes = 1000
+	// MaxArgon2Parallelism the maximum parallelism.
+	MaxArgon2Parallelism = 255
+)
+
+var (
+	// errMAC the MAC not valid.
+	errMAC = errors.New("MAC not valid.")
+)
+
+//
+// PuttyKey a PuTTY (.ppk) key file.
+// Versions 2 and 3 are supported.
+type PuttyKey struct {
+	// Version the file version.
+	Version int
+	// Algorithm the key algorithm (ssh-rsa, ssh-ed25519, ...).
+	Algorithm string
+	// Encryption (none|aes256-cbc).
+	Encryption string
+	// Comment the key comment.
+	Comment string
+	// Public the public key blob.
+	Public []byte
+	// Private the private key blob.
+	Private []byte
+	// MAC the private MAC.
+	MAC []byte
+	// KDF the (version 3) key derivation parameters.
+	KDF map[string]string
+}
+
+//
+// Parse the file.
+func (r *PuttyKey) Parse(content []byte) (err error) {
+	*r = PuttyKey{KDF: make(map[string]string)}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		part := strings.SplitN(line, ":", 2)
+		if len(part) != 2 {
+			err = errors.New("line: '" + line + "' not valid.")
+			return
+		}
+		key := part[0]
+		value := strings.TrimSpace(part[1])
+		switch {
+		case strings.HasPrefix(key, puttyHeader):
+			r.Version, err = strconv.Atoi(strings.TrimPrefix(key, puttyHeader))
+			if err != nil {
+				return
+			}
+			r.Algorithm = value
+		case key == "Encryption":
+			r.Encryption = value
+		case key == "Comment":
+			r.Comment = value
+		case key == "Public-Lines":
+			r.Public, err = r.blob(scanner, value)
+		case key == "Private-Lines":
+			r.Private, err = r.blob(scanner, value)
+		case key == "Private-MAC":
+			r.MAC, err = hex.DecodeString(value)
+		default:
+			r.KDF[key] = value
+		}
+		if err != nil {
+			return
+		}
+	}
+	if len(r.Public) == 0 || len(r.Private) == 0 || len(r.MAC) == 0 {
+		err = errors.New("key file not complete.")
+	}
+	return
+}
+
+//
+// Encrypted returns true when the private key is encrypted.
+func (r *PuttyKey) Encrypted() (encrypted bool) {
+	encrypted = r.Encryption != "none"
+	return
+}
+
+//
+// Decrypt the private key (blob) and verify the MAC.
+// The passphrase is ignored when not encrypted.
+func (r *PuttyKey) Decrypt(passphrase string) (err error) {
+	if !r.Encrypted() {
+		passphrase = ""
+	}
+	var cipherKey, iv, macKey []byte
+	var newHash func() hash.Hash
+	switch r.Version {
+	case 2:
+		cipherKey, iv, macKey = r.v2Keys(passphrase)
+		newHash = sha1.New
+	case 3:
+		cipherKey, iv, macKey, err = r.v3Keys(passphrase)
+		if err != nil {
+			return
+		}
+		newHash = sha256.New
+	default:
+		err = errors.New("version: " + strconv.Itoa(r.Version) + " not supported.")
+		return
+	}
+	if r.Encrypted() {
+		if len(r.Private)%aes.BlockSize != 0 {
+			err = errors.New("private key length not valid.")
+			return
+		}
+		block, cErr := aes.NewCipher(cipherKey)
+		if cErr != nil {
+			err = cErr
+			return
+		}
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(r.Private, r.Private)
+	}
+	mac := hmac.New(newHash, macKey)
+	for _, field := range [][]byte{
+		[]byte(r.Algorithm),
+		[]byte(r.Encryption),
+		[]byte(r.Comment),
+		r.Public,
+		r.Private,
+	} {
+		_ = binary.Write(mac, binary.BigEndian, uint32(len(field)))
+		_, _ = mac.Write(field)
+	}
+	if !hmac.Equal(mac.Sum(nil), r.MAC) {
+		err = errMAC
+	}
+	return
+}
+
+//
+// PrivateKey returns the (decrypted) private key.
+// Supported: ssh-rsa, ecdsa-sha2-*, ssh-ed25519.
+func (r *PuttyKey) PrivateKey() (key interface{}, err error) {
+	public, err := xssh.ParsePublicKey(r.Public)
+	if err != nil {
+		return
+	}
+	cryptoKey, cast := public.(xssh.CryptoPublicKey)
+	if !cast {
+		err = errors.New("algorithm: " + r.Algorithm + " not supported.")
+		return
+	}
+	blob := &sshBlob{content: r.Private}
+	switch pub := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		d := blob.mpint()
+		p := blob.mpint()
+		q := blob.mpint()
+		if blob.err != nil {
+			err = blob.err
+			return
+		}
+		rsaKey := &rsa.PrivateKey{
+			PublicKey: *pub,
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		rsaKey.Precompute()
+		key = rsaKey
+	case *ecdsa.PublicKey:
+		d := blob.mpint()
+		if blob.err != nil {
+			err = blob.err
+			return
+		}
+		x, y := pub.Curve.ScalarBaseMult(d.Bytes())
+		if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+			err = errors.New("private key does not match the public key.")
+			return
+		}
+		key = &ecdsa.PrivateKey{
+			PublicKey: *pub,
+			D:         d,
+		}
+	case ed25519.PublicKey:
+		// little-endian, trailing zeros may be omitted.
+		seed := blob.string()
+		if blob.err != nil {
+			err = blob.err
+			return
+		}
+		if len(seed) > ed25519.SeedSize {
+			err = errors.New("private key length not valid.")
+			return
+		}
+		seed = append(seed, make([]byte, ed25519.SeedSize-len(seed))...)
+		edKey := ed25519.NewKeyFromSeed(seed)
+		if !bytes.Equal(edKey.Public().(ed25519.PublicKey), pub) {
+			err = errors.New("private key does not match the public key.")
+			return
+		}
+		key = edKey
+	default:
+		err = errors.New("algorithm: " + r.Algorithm + " not supported.")
+	}
+	return
+}
+
+//
+// blob reads the (base64) lines.
+func (r *PuttyKey) blob(scanner *bufio.Scanner, value string) (blob []byte, err error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	encoded := ""
+	for i := 0; i < n; i++ {
+		if !scanner.Scan() {
+			err = errors.New("key file not complete.")
+			return
+		}
+		encoded += strings.TrimSpace(scanner.Text())
+	}
+	blob, err = base64.StdEncoding.DecodeString(encoded)
+	return
+}
+
+//
+// v2Keys derives the (version 2) cipher key, IV and MAC key.
+func (r *PuttyKey) v2Keys(passphrase string) (cipherKey, iv, macKey []byte) {
+	for i := uint32(0); i < 2; i++ {
+		h := sha1.New()
+		_ = binary.Write(h, binary.BigEndian, i)
+		_, _ = h.Write([]byte(passphrase))
+		cipherKey = h.Sum(cipherKey)
+	}
+	cipherKey = cipherKey[:32]
+	iv = make([]byte, aes.BlockSize)
+	h := sha1.Sum([]byte("putty-private-key-file-mac-key" + passphrase))
+	macKey = h[:]
+	return
+}
+
+//
+// v3Keys derives the (version 3) cipher key, IV and MAC key.
+// The MAC key is empty when not encrypted.
+func (r *PuttyKey) v3Keys(passphrase string) (cipherKey, iv, macKey []byte, err error) {
+	if !r.Encrypted() {
+		return
+	}
+	salt, err := hex.DecodeString(r.KDF["Argon2-Salt"])
+	if err != nil {
+		return
+	}
+	var n [3]int
+	for i, key := range []string{
+		"Argon2-Memory",
+		"Argon2-Passes",
+		"Argon2-Parallelism",
+	} {
+		n[i], err = strconv.Atoi(r.KDF[key])
+		if err != nil {
+			return
+		}
+	}
+	memory, passes, parallelism := n[0], n[1], n[2]
+	switch {
+	case memory < 1 || memory > MaxArgon2Memory:
+		err = errors.New("Argon2-Memory: " + strconv.Itoa(memory) + " not valid.")
+		return
+	case passes < 1 || passes > MaxArgon2Passes:
+		err = errors.New("Argon2-Passes: " + strconv.Itoa(passes) + " not valid.")
+		return
+	case parallelism < 1 || parallelism > MaxArgon2Parallelism:
+		err = errors.New("Argon2-Parallelism: " + strconv.Itoa(parallelism) + " not valid.")
+		return
+	}
+	var derived []byte
+	switch kdf := r.KDF["Key-Derivation"]; kdf {
+	case "Argon2id":
+		derived = argon2.IDKey([]byte(passphrase), salt, uint32(passes), uint32(memory), uint8(parallelism), 80)
+	case "Argon2i":
+		derived = argon2.Key([]byte(passphrase), salt, uint32(passes), uint32(memory), uint8(parallelism), 80)
+	default:
+		err = errors.New("key derivation: " + kdf + " not supported.")
+		return
+	}
+	cipherKey = derived[:32]
+	iv = derived[32:48]
+	macKey = derived[48:]
+	return
+}
+
+//
+// putty parses (and converts) a PuTTY key.
+func (r *Key) putty(content []byte) (key interface{}, err error) {
+	ppk := &PuttyKey{}
+	err = ppk.Parse(content)
+	if err != nil {
+		err = &KeyError{
+			Identity: r.Identity.Name,
+			Reason:   "PuTTY " + err.Error(),
+		}
+		return
+	}
+	if ppk.Encrypted() && r.Identity.Password == "" {
+		err = &PassphraseError{
+			Identity: r.Identity.Name,
+			Missing:  true,
+		}
+		return
+	}
+	switch ppk.Encryption {
+	case "none", "aes256-cbc":
+	default:
+		err = &FormatError{
+			Identity: r.Identity.Name,
+			Format:   PuTTY,
+			Reason:   "Encryption: " + ppk.Encryption + " not supported.",
+		}
+		return
+	}
+	err = ppk.Decrypt(r.Identity.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, errMAC) && ppk.Encrypted():
+			err = &PassphraseError{
+				Identity: r.Identity.Name,
+			}
+		case errors.Is(err, errMAC):
+			err = &KeyError{
+				Identity: r.Identity.Name,
+				Reason:   "PuTTY " + err.Error(),
+			}
+		default:
+			err = &FormatError{
+				Identity: r.Identity.Name,
+				Format:   PuTTY,
+				Reason:   err.Error(),
+			}
+		}
+		return
+	}
+	key, err = ppk.PrivateKey()
+	if err != nil {
+		err = &FormatError{
+			Identity: r.Identity.Name,
+			Format:   PuTTY,
+			Reason:   err.Error(),
+		}
+	}
+	return
+}
+
+//
+// sshBlob reads (SSH wire format) fields.
+type sshBlob struct {
+	content []byte
+	err     error
+}
+
+//
+// string reads a string.
+func (r *sshBlob) string() (s []byte) {
+	if r.err != nil {
+		return
+	}
+	if len(r.content) < 4 {
+		r.err = errors.New("key blob not valid.")
+		return
+	}
+	n := binary.BigEndian.Uint32(r.content)
+	r.content = r.content[4:]
+	if uint32(len(r.content)) < n {
+		r.err = errors.New("key blob not valid.")
+		return
+	}
+	s = append([]byte{}, r.content[:n]...)
+	r.content = r.content[n:]
+	return
+}
+
+//
+// mpint reads a (positive) multiple precision integer.
+func (r *sshBlob) mpint() (n *big.Int) {
+	n = new(big.Int).SetBytes(r.string())
+	return
+}
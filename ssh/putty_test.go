@@ -0,0 +1,305 @@
+package ssh
+
+import (
+	"errors"
+	"github.com/konveyor/tackle2-hub/api"
+	xssh "golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Passphrase used to encrypt the fixtures.
+const testPassphrase = "secret"
+
+func TestPuttyKey(t *testing.T) {
+	for _, kind := range []string{"rsa", "ecdsa", "ed25519"} {
+		public := testPublicKey(t, kind+".pub")
+		for _, name := range []string{
+			kind + "-v2",
+			kind + "-v2-encrypted",
+			kind + "-v3",
+			kind + "-v3-encrypted",
+		} {
+			t.Run(name, func(t *testing.T) {
+				key := &Key{}
+				err := key.With(
+					&api.Identity{
+						Name:     name,
+						Key:      testFixture(t, name+".ppk"),
+						Password: testPassphrase,
+					})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if key.Format != PuTTY || !key.Converted() {
+					t.Errorf("format: %s, converted: %t", key.Format, key.Converted())
+				}
+				if key.Type() != public.Type() {
+					t.Errorf("type: %s, expected: %s", key.Type(), public.Type())
+				}
+				if key.Fingerprint() != Fingerprint(public) {
+					t.Errorf("fingerprint: %s, expected: %s", key.Fingerprint(), Fingerprint(public))
+				}
+			})
+		}
+	}
+}
+
+func TestPuttyKeyPassphrase(t *testing.T) {
+	for _, name := range []string{
+		"rsa-v2-encrypted",
+		"rsa-v3-encrypted",
+		"ecdsa-v2-encrypted",
+		"ecdsa-v3-encrypted",
+		"ed25519-v2-encrypted",
+		"ed25519-v3-encrypted",
+	} {
+		t.Run(name, func(t *testing.T) {
+			content := testFixture(t, name+".ppk")
+			cases := []struct {
+				password string
+				missing  bool
+			}{
+				{password: "wrong"},
+				{password: testPassphrase + " "},
+				{password: "", missing: true},
+			}
+			for _, c := range cases {
+				key := &Key{}
+				err := key.With(
+					&api.Identity{
+						Name:     name,
+						Key:      content,
+						Password: c.password,
+					})
+				pErr := &PassphraseError{}
+				if !errors.As(err, &pErr) {
+					t.Fatalf("password: %q, expected PassphraseError, got: %v", c.password, err)
+				}
+				if pErr.Missing != c.missing {
+					t.Errorf("password: %q, missing: %t, expected: %t", c.password, pErr.Missing, c.missing)
+				}
+			}
+		})
+	}
+}
+
+func TestPuttyKeyNotValid(t *testing.T) {
+	v2 := testFixture(t, "rsa-v2.ppk")
+	v3 := testFixture(t, "ed25519-v3.ppk")
+	encrypted := testFixture(t, "ecdsa-v3-encrypted.ppk")
+	cases := []struct {
+		name    string
+		content string
+		target  error
+	}{
+		{
+			name:    "truncated",
+			content: v2[:len(v2)/2],
+			target:  &KeyError{},
+		},
+		{
+			name:    "MAC missing",
+			content: testDropLine(v3, "Private-MAC:"),
+			target:  &KeyError{},
+		},
+		{
+			name:    "header only",
+			content: "PuTTY-User-Key-File-3: ssh-ed25519\n",
+			target:  &KeyError{},
+		},
+		{
+			name:    "line not valid",
+			content: strings.Replace(v3, "Comment:", "Comment", 1),
+			target:  &KeyError{},
+		},
+		{
+			name:    "base64 not valid",
+			content: testReplaceLine(v3, "Private-Lines:", 1, "!!!!"),
+			target:  &KeyError{},
+		},
+		{
+			name:    "MAC not valid",
+			content: testReplaceLine(v3, "Private-MAC:", 0, "Private-MAC: "+strings.Repeat("00", 32)),
+			target:  &KeyError{},
+		},
+		{
+			name:    "comment changed",
+			content: strings.Replace(v2, "Comment: rsa-v2", "Comment: changed", 1),
+			target:  &KeyError{},
+		},
+		{
+			name:    "version not supported",
+			content: strings.Replace(v3, "PuTTY-User-Key-File-3", "PuTTY-User-Key-File-9", 1),
+			target:  &FormatError{},
+		},
+		{
+			name:    "encryption not supported",
+			content: strings.Replace(encrypted, "aes256-cbc", "aes128-ctr", 1),
+			target:  &FormatError{},
+		},
+		{
+			name:    "Argon2 parallelism 0",
+			content: strings.Replace(encrypted, "Argon2-Parallelism: 1", "Argon2-Parallelism: 0", 1),
+			target:  &FormatError{},
+		},
+		{
+			name:    "Argon2 parallelism 256",
+			content: strings.Replace(encrypted, "Argon2-Parallelism: 1", "Argon2-Parallelism: 256", 1),
+			target:  &FormatError{},
+		},
+		{
+			name:    "Argon2 passes 0",
+			content: testReplaceLine(encrypted, "Argon2-Passes:", 0, "Argon2-Passes: 0"),
+			target:  &FormatError{},
+		},
+		{
+			name:    "Argon2 memory too large",
+			content: testReplaceLine(encrypted, "Argon2-Memory:", 0, "Argon2-Memory: 4294967296"),
+			target:  &FormatError{},
+		},
+		{
+			name:    "Argon2 memory negative",
+			content: testReplaceLine(encrypted, "Argon2-Memory:", 0, "Argon2-Memory: -1"),
+			target:  &FormatError{},
+		},
+		{
+			name:    "key derivation not supported",
+			content: strings.Replace(encrypted, "Argon2id", "scrypt", 1),
+			target:  &FormatError{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key := &Key{}
+			err := key.With(
+				&api.Identity{
+					Name:     c.name,
+					Key:      c.content,
+					Password: testPassphrase,
+				})
+			if !errors.Is(err, c.target) {
+				t.Fatalf("expected: %T, got: %v", c.target, err)
+			}
+		})
+	}
+}
+
+func TestPKCS8Encrypted(t *testing.T) {
+	for _, kind := range []string{"rsa", "ec", "ed25519"} {
+		name := "pkcs8-" + kind + "-encrypted"
+		t.Run(name, func(t *testing.T) {
+			public := testPublicKey(t, "pkcs8-"+kind+".pub")
+			content := testFixture(t, name+".pem")
+			key := &Key{}
+			err := key.With(
+				&api.Identity{
+					Name:     name,
+					Key:      content,
+					Password: testPassphrase,
+				})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if key.Format != PKCS8Encrypted || !key.Converted() {
+				t.Errorf("format: %s, converted: %t", key.Format, key.Converted())
+			}
+			if key.Fingerprint() != Fingerprint(public) {
+				t.Errorf("fingerprint: %s, expected: %s", key.Fingerprint(), Fingerprint(public))
+			}
+			for _, password := range []string{"wrong", ""} {
+				key = &Key{}
+				err = key.With(
+					&api.Identity{
+						Name:     name,
+						Key:      content,
+						Password: password,
+					})
+				pErr := &PassphraseError{}
+				if !errors.As(err, &pErr) {
+					t.Fatalf("password: %q, expected PassphraseError, got: %v", password, err)
+				}
+				if pErr.Missing != (password == "") {
+					t.Errorf("password: %q, missing: %t", password, pErr.Missing)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyFormat(t *testing.T) {
+	cases := []struct {
+		name   string
+		format string
+	}{
+		{name: "rsa-v2.ppk", format: PuTTY},
+		{name: "ed25519-v3-encrypted.ppk", format: PuTTY},
+		{name: "pkcs8-rsa-encrypted.pem", format: PKCS8Encrypted},
+		{name: "rsa.pub", format: PublicOnly},
+	}
+	for _, c := range cases {
+		format := KeyFormat([]byte(testFixture(t, c.name)))
+		if format != c.format {
+			t.Errorf("%s: format: %q, expected: %q", c.name, format, c.format)
+		}
+	}
+	if format := KeyFormat([]byte("not a key")); format != "" {
+		t.Errorf("format: %q, expected: empty", format)
+	}
+	key := &Key{}
+	err := key.With(&api.Identity{Name: "public", Key: testFixture(t, "rsa.pub")})
+	if !errors.Is(err, &KeyError{}) {
+		t.Errorf("public: expected KeyError, got: %v", err)
+	}
+	err = key.With(&api.Identity{Name: "unknown", Key: "not a key"})
+	if !errors.Is(err, &FormatError{}) {
+		t.Errorf("unknown: expected FormatError, got: %v", err)
+	}
+}
+
+// testFixture returns the content of the file in testdata.
+func testFixture(t *testing.T, name string) (content string) {
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = string(b)
+	return
+}
+
+// testPublicKey returns the (authorized_keys) public key in testdata.
+func testPublicKey(t *testing.T, name string) (key xssh.PublicKey) {
+	key, _, _, _, err := xssh.ParseAuthorizedKey([]byte(testFixture(t, name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// testDropLine returns the content without the line with the prefix.
+func testDropLine(content, prefix string) (dropped string) {
+	var kept []string
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			kept = append(kept, line)
+		}
+	}
+	dropped = strings.Join(kept, "\n")
+	return
+}
+
+// testReplaceLine returns the content with the line at the
+// offset from the line with the prefix replaced.
+func testReplaceLine(content, prefix string, offset int, replacement string) (replaced string) {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, prefix) && i+offset < len(lines) {
+			lines[i+offset] = replacement
+			break
+		}
+	}
+	replaced = strings.Join(lines, "\n")
+	return
+}
@@ -5,15 +5,35 @@ import (
 	"fmt"
 	liberr "github.com/jortel/go-utils/error"
 	"github.com/konveyor/tackle2-addon/command"
+	"github.com/konveyor/tackle2-addon/proxy"
+	"github.com/konveyor/tackle2-addon/teardown"
 	hub "github.com/konveyor/tackle2-hub/addon"
 	"github.com/konveyor/tackle2-hub/api"
 	"github.com/konveyor/tackle2-hub/nas"
+	xssh "golang.org/x/crypto/ssh"
+	"net"
 	"os"
+	"os/exec"
 	pathlib "path"
 	"strings"
 	"time"
 )
 
+const (
+	// KnownHosts the known hosts file.
+	KnownHosts = "/etc/ssh/ssh_known_hosts"
+	// NetcatPath the netcat used to connect through proxies.
+	NetcatPath = "/usr/bin/nc"
+)
+
+// Netcat implementations.
+const (
+	// Ncat nmap-ncat.
+	Ncat = "ncat"
+	// OpenBSD OpenBSD netcat.
+	OpenBSD = "openbsd"
+)
+
 var (
 	addon   = hub.Addon
 	HomeDir = ""
@@ -31,20 +51,22 @@ func init() {
 //
 // Agent agent.
 type Agent struct {
+	// Config (optional) ssh configuration used
+	// to connect when scanning host keys.
+	Config *Config
+	// Pins (optional) pinned host keys and fingerprints.
+	Pins Pins
 }
 
 //
-// Start the ssh-agent.
+// Start the (in-process) ssh agent.
+// The agent is registered to be stopped on teardown.
 func (r *Agent) Start() (err error) {
-	pid := os.Getpid()
-	socket := fmt.Sprintf("/tmp/agent.%d", pid)
-	cmd := command.Command{Path: "/usr/bin/ssh-agent"}
-	cmd.Options.Add("-a", socket)
-	err = cmd.Run()
+	err = server.Start()
 	if err != nil {
 		return
 	}
-	_ = os.Setenv("SSH_AUTH_SOCK", socket)
+	_ = os.Setenv("SSH_AUTH_SOCK", server.Path)
 	err = nas.MkDir(SSHDir, 0700)
 	if err != nil {
 		return
@@ -57,22 +79,95 @@ func (r *Agent) Start() (err error) {
 
 //
 // Add ssh key.
+// The key is parsed and validated before the agent is started.
+// The host may be: host or host:port.
+// The host key is not scanned when the host is empty.
 func (r *Agent) Add(id *api.Identity, host string) (err error) {
 	if id.Key == "" {
 		return
 	}
-	addon.Activity("[SSH] Adding key: %s", id.Name)
-	suffix := fmt.Sprintf("id_%d", id.ID)
-	path := pathlib.Join(
+	key := &Key{}
+	err = key.With(id)
+	if err != nil {
+		return
+	}
+	if !server.Running() {
+		err = r.Start()
+		if err != nil {
+			return
+		}
+	}
+	err = r.add(key)
+	if err != nil {
+		return
+	}
+	if host == "" {
+		return
+	}
+	err = r.Scan(host)
+	return
+}
+
+//
+// KeyPath returns the path of the (public) key file for the
+// identity. Used (IdentityFile) to select the key in the agent.
+// The private key is held only in memory by the agent.
+func KeyPath(id uint) (path string) {
+	path = pathlib.Join(
 		SSHDir,
-		suffix)
-	found, err := nas.Exists(path)
-	if found || err != nil {
+		fmt.Sprintf("id_%d.pub", id))
+	return
+}
+
+//
+// CertificatePath returns the path of the (OpenSSH)
+// certificate file for the identity.
+func CertificatePath(id uint) (path string) {
+	path = pathlib.Join(
+		SSHDir,
+		fmt.Sprintf("id_%d-cert.pub", id))
+	return
+}
+
+//
+// add the key to the agent and write the public key
+// (and certificate) files.
+func (r *Agent) add(key *Key) (err error) {
+	added, err := server.Add(key)
+	if err != nil || !added {
 		return
 	}
+	id := key.Identity
+	format := key.Format
+	if key.Converted() {
+		format += ", converted"
+	}
+	addon.Activity(
+		"[SSH] Added key (%s): %s %s %s",
+		format,
+		id.Name,
+		key.Type(),
+		key.Fingerprint())
+	err = r.writeFile(
+		KeyPath(id.ID),
+		xssh.MarshalAuthorizedKey(key.Public))
+	if err != nil {
+		return
+	}
+	if key.Certificate != nil {
+		err = r.writeFile(
+			CertificatePath(id.ID),
+			xssh.MarshalAuthorizedKey(key.Certificate))
+	}
+	return
+}
+
+//
+// writeFile writes a file.
+func (r *Agent) writeFile(path string, content []byte) (err error) {
 	f, err := os.OpenFile(
 		path,
-		os.O_RDWR|os.O_CREATE,
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC,
 		0600)
 	if err != nil {
 		err = liberr.Wrap(
@@ -81,7 +176,8 @@ func (r *Agent) Add(id *api.Identity, host string) (err error) {
 			path)
 		return
 	}
-	_, err = f.Write([]byte(r.format(id.Key)))
+	teardown.File(path)
+	_, err = f.Write(content)
 	if err != nil {
 		err = liberr.Wrap(
 			err,
@@ -89,85 +185,246 @@ func (r *Agent) Add(id *api.Identity, host string) (err error) {
 			path)
 	}
 	_ = f.Close()
-	err = r.writeAsk(id)
+	return
+}
+
+//
+// Scan the host key and add it to the known hosts.
+// The host may be: host or host:port.
+// Pinned keys are trusted without scanning. Pinned fingerprints
+// are verified using the scanned keys. When not pinned, the host
+// must be known or the scanned keys are trusted (on first use)
+// only when permitted by the KeyScanSetting.
+func (r *Agent) Scan(host string) (err error) {
+	settings := make(map[string][]string)
+	err = setting(KnownHostsSetting, &settings)
 	if err != nil {
 		return
 	}
-	ctx, fn := context.WithTimeout(
-		context.TODO(),
-		time.Second)
-	defer fn()
-	cmd := command.Command{Path: "/usr/bin/ssh-add"}
-	cmd.Options.Add(path)
-	err = cmd.RunWith(ctx)
-	if err != nil {
+	pins := Pins{}
+	pins.With(settings)
+	pins.With(r.Pins)
+	found := pins.Find(host)
+	keys := PinnedKeys(host, found)
+	if len(keys) > 0 {
+		err = Trust(keys, "pinned")
 		return
 	}
-	cmd = command.Command{Path: "/usr/bin/ssh-keyscan"}
-	cmd.Options.Add(host)
-	err = cmd.Run()
-	if err != nil {
+	if len(found) > 0 {
+		var scanned []byte
+		scanned, err = r.scan(host)
+		if err != nil {
+			return
+		}
+		keys, err = Verify(host, found, scanned)
+		if err != nil {
+			return
+		}
+		err = Trust(keys, "verified")
 		return
 	}
-	known := "/etc/ssh/ssh_known_hosts"
-	f, err = os.OpenFile(
-		known, os.O_RDWR|os.O_APPEND|os.O_CREATE,
-		0600)
+	if Known(host) {
+		return
+	}
+	allowed := false
+	err = setting(KeyScanSetting, &allowed)
 	if err != nil {
-		err = liberr.Wrap(
-			err,
-			"path",
-			path)
 		return
 	}
-	_, err = f.Write(cmd.Output)
+	if !allowed {
+		err = &HostKeyError{
+			Host:   Normalize(host),
+			Reason: "not pinned and keyscan not enabled (" + KeyScanSetting + ").",
+		}
+		return
+	}
+	scanned, err := r.scan(host)
 	if err != nil {
-		err = liberr.Wrap(
-			err,
-			"path",
-			path)
+		return
 	}
-	_ = f.Close()
-	addon.Activity("[FILE] Created %s.", path)
+	keys = Parse(host, scanned)
+	if len(keys) == 0 {
+		err = &HostKeyError{
+			Host:   Normalize(host),
+			Reason: "no keys scanned.",
+		}
+		return
+	}
+	err = Trust(keys, "scanned")
 	return
 }
 
 //
-// Ensure key formatting.
-func (r *Agent) format(in string) (out string) {
-	if in != "" {
-		out = strings.TrimSpace(in) + "\n"
+// scan the host keys (known_hosts format).
+// When proxied, the host key is scanned using ssh (and the
+// proxy or bastion) because ssh-keyscan connects only directly.
+func (r *Agent) scan(host string) (keys []byte, err error) {
+	if r.proxied(host) {
+		keys, err = r.scanWithConfig(host)
+	} else {
+		keys, err = r.keyscan(host)
 	}
 	return
 }
 
 //
-// writeAsk writes script that returns the key password.
-func (r *Agent) writeAsk(id *api.Identity) (err error) {
-	path := "/tmp/ask.sh"
-	f, err := os.OpenFile(
-		path,
-		os.O_RDWR|os.O_CREATE,
-		0700)
+// proxied returns true when the host (host:port) is
+// connected using a proxy or bastion (configured).
+func (r *Agent) proxied(host string) (proxied bool) {
+	if r.Config == nil {
+		return
+	}
+	if h, _, pErr := net.SplitHostPort(host); pErr == nil {
+		host = h
+	}
+	matched := r.Config.Find(host)
+	proxied = matched != nil && matched.Proxied()
+	return
+}
+
+//
+// keyscan scans the host key using ssh-keyscan.
+func (r *Agent) keyscan(host string) (keys []byte, err error) {
+	cmd := command.Command{Path: "/usr/bin/ssh-keyscan"}
+	if h, port, pErr := net.SplitHostPort(host); pErr == nil {
+		cmd.Options.Add("-p", port)
+		host = h
+	}
+	cmd.Options.Add(host)
+	err = cmd.Run()
 	if err != nil {
-		err = liberr.Wrap(
-			err,
-			"path",
-			path)
 		return
 	}
-	script := fmt.Sprintf(
-		"#!/bin/sh\necho '%s'",
-		id.Password)
-	_, err = f.Write([]byte(script))
+	keys = cmd.Output
+	return
+}
+
+//
+// scanWithConfig scans the host key using ssh and the configuration.
+// The connection is closed after the key exchange (no authentication)
+// and the key recorded in a (temporary) known hosts file.
+func (r *Agent) scanWithConfig(host string) (keys []byte, err error) {
+	path := r.Config.Path + ".known_hosts"
+	_ = os.Remove(path)
+	teardown.File(path)
+	cmd := command.Command{Path: "/usr/bin/ssh"}
+	cmd.Options.Add("-F", r.Config.Path)
+	if h, port, pErr := net.SplitHostPort(host); pErr == nil {
+		cmd.Options.Add("-p", port)
+		host = h
+	}
+	cmd.Options.Add("-o", "BatchMode=yes")
+	cmd.Options.Add("-o", "StrictHostKeyChecking=accept-new")
+	cmd.Options.Add("-o", "UserKnownHostsFile="+path)
+	cmd.Options.Add("-o", "PreferredAuthentications=none")
+	cmd.Options.Add(host, "true")
+	ctx, fn := context.WithTimeout(
+		context.TODO(),
+		time.Minute)
+	defer fn()
+	_ = cmd.RunWith(ctx)
+	keys, _ = os.ReadFile(path)
+	if len(keys) == 0 {
+		err = liberr.New(
+			"ssh host key scan failed.",
+			"host",
+			host)
+	}
+	return
+}
+
+//
+// ProxyCommand returns the ssh ProxyCommand used to connect
+// through the SOCKS or HTTP (CONNECT) proxy. Requires netcat
+// (NetcatPath) with proxy support: nmap-ncat (UBI/RHEL) or
+// OpenBSD netcat. Proxy authentication requires nmap-ncat.
+func ProxyCommand(p *proxy.Proxy) (command string, err error) {
+	kind, err := netcat()
 	if err != nil {
-		err = liberr.Wrap(
-			err,
+		return
+	}
+	switch kind {
+	case Ncat:
+		proxyType := "http"
+		options := ""
+		switch p.Scheme {
+		case "socks4", "socks4a":
+			proxyType = "socks4"
+		case "socks5", "socks5h":
+			proxyType = "socks5"
+			options = " --proxy-dns remote"
+		}
+		if p.User != "" {
+			auth := p.User
+			if p.Password != "" {
+				auth += ":" + p.Password
+			}
+			if strings.ContainsAny(auth, "\r\n") {
+				err = liberr.New(
+					"Proxy credentials not valid.",
+					"proxy",
+					p.String())
+				return
+			}
+			options += " --proxy-auth " + quote(auth)
+		}
+		command = fmt.Sprintf(
+			"%s --proxy %s --proxy-type %s%s %%h %%p",
+			NetcatPath,
+			p.Address(),
+			proxyType,
+			options)
+	default:
+		if p.User != "" {
+			err = liberr.New(
+				"Proxy authentication requires nmap-ncat.",
+				"proxy",
+				p.String())
+			return
+		}
+		version := "connect"
+		switch p.Scheme {
+		case "socks4", "socks4a":
+			version = "4"
+		case "socks5", "socks5h":
+			version = "5"
+		}
+		command = fmt.Sprintf(
+			"%s -X %s -x %s %%h %%p",
+			NetcatPath,
+			version,
+			p.Address())
+	}
+	return
+}
+
+//
+// quote returns the (ProxyCommand) argument quoted for the
+// shell and escaped for ssh_config (%) tokens.
+func quote(arg string) (quoted string) {
+	quoted = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	quoted = strings.ReplaceAll(quoted, "%", "%%")
+	return
+}
+
+//
+// netcat returns the netcat (NetcatPath) implementation.
+// An error is returned when not installed or proxies
+// are not supported.
+func netcat() (kind string, err error) {
+	output, _ := exec.Command(NetcatPath, "-h").CombinedOutput()
+	usage := string(output)
+	switch {
+	case strings.Contains(usage, "Ncat"):
+		kind = Ncat
+	case strings.Contains(usage, "OpenBSD"),
+		strings.Contains(usage, "proxy_protocol"):
+		kind = OpenBSD
+	default:
+		err = liberr.New(
+			"Netcat with proxy support (nmap-ncat or OpenBSD netcat) required by ssh.",
 			"path",
-			path)
+			NetcatPath)
 	}
-	_ = os.Setenv("SSH_ASKPASS", path)
-	_ = os.Setenv("DISPLAY", "1")
-	_ = f.Close()
 	return
 }
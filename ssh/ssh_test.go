@@ -0,0 +1,28 @@
+package ssh
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	for _, arg := range []string{
+		"user:password",
+		"user:p@ss word",
+		"user:it's",
+		"user:$(id)`id`;|&\"\\",
+		"user:100%",
+	} {
+		quoted := quote(arg)
+		// ssh expands (%%) tokens before running the shell.
+		quoted = strings.ReplaceAll(quoted, "%%", "%")
+		output, err := exec.Command("/bin/sh", "-c", "printf %s "+quoted).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != arg {
+			t.Errorf("quote(%q): shell argument: %q", arg, output)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Package teardown provides support for addons to
+clean up secret-bearing files and resources.
+Files are securely deleted (overwritten then removed)
+and functions called by Run(). The addon lifecycle
+(including signals) is not managed by the package.
+Addons must run the teardown when the addon completes
+or fails. Example:
+
+	addon.Run(func() (err error) {
+		defer teardown.Run()
+		...
+	})
+*/
+package teardown
+
+import (
+	"errors"
+	hub "github.com/konveyor/tackle2-hub/addon"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	addon    = hub.Addon
+	registry = Registry{}
+)
+
+//
+// File registers a file (or directory) to be securely deleted.
+func File(path string) {
+	registry.File(path)
+}
+
+//
+// Func registers a function to be called.
+func Func(name string, fn func() error) {
+	registry.Func(name, fn)
+}
+
+//
+// Run the teardown.
+// Returns what was cleaned.
+func Run() (cleaned []string) {
+	cleaned = registry.Run()
+	return
+}
+
+//
+// Registry of resources to be cleaned up.
+type Registry struct {
+	mutex   sync.Mutex
+	entries []Entry
+}
+
+//
+// File registers a file (or directory) to be securely deleted.
+func (r *Registry) File(path string) {
+	r.add(
+		Entry{
+			Name: path,
+			Fn: func() (err error) {
+				err = Delete(path)
+				return
+			},
+		})
+}
+
+//
+// Func registers a function to be called.
+func (r *Registry) Func(name string, fn func() error) {
+	r.add(
+		Entry{
+			Name: name,
+			Fn:   fn,
+		})
+}
+
+//
+// Run the teardown.
+// Entries are run in reverse order of registration.
+// Returns what was cleaned.
+func (r *Registry) Run() (cleaned []string) {
+	r.mutex.Lock()
+	entries := r.entries
+	r.entries = nil
+	r.mutex.Unlock()
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		err := entry.Fn()
+		if err != nil {
+			addon.Activity(
+				"[TEARDOWN] %s failed: %s",
+				entry.Name,
+				err.Error())
+			continue
+		}
+		cleaned = append(cleaned, entry.Name)
+		addon.Activity("[TEARDOWN] Cleaned: %s", entry.Name)
+	}
+	return
+}
+
+//
+// add an entry.
+func (r *Registry) add(entry Entry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, e := range r.entries {
+		if e.Name == entry.Name {
+			return
+		}
+	}
+	r.entries = append(r.entries, entry)
+}
+
+//
+// Entry registered resource.
+type Entry struct {
+	Name string
+	Fn   func() error
+}
+
+//
+// Delete securely deletes a file (or directory).
+// Regular files are overwritten with zeros before removed.
+func Delete(path string) (err error) {
+	err = filepath.WalkDir(
+		path,
+		func(p string, d fs.DirEntry, wErr error) (err error) {
+			if wErr != nil {
+				err = wErr
+				return
+			}
+			if d.Type().IsRegular() {
+				err = overwrite(p)
+			}
+			return
+		})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	err = os.RemoveAll(path)
+	return
+}
+
+//
+// overwrite the file content with zeros.
+func overwrite(path string) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	st, err := f.Stat()
+	if err != nil {
+		return
+	}
+	zeros := make([]byte, 4096)
+	for n := st.Size(); n > 0; {
+		chunk := int64(len(zeros))
+		if n < chunk {
+			chunk = n
+		}
+		_, err = f.Write(zeros[:chunk])
+		if err != nil {
+			return
+		}
+		n -= chunk
+	}
+	err = f.Sync()
+	return
+}